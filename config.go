@@ -7,19 +7,31 @@ import (
 	"io/ioutil"
 	"regexp"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
 	Data           map[string]interface{} `json:"data,omitempty"`
 	Branches       []BranchConfig         `json:"branches"`
 	DataFileFields []string               `json:"data-file"`
+	DirtySuffix    string                 `json:"dirty-suffix,omitempty"`
+	Rcs            string                 `json:"rcs,omitempty"`
+	CiPrecedence   string                 `json:"ci-precedence,omitempty"`
+	Environment    []EnvironmentConfig    `json:"environment,omitempty"`
+	DataFileFormat string                 `json:"data-file-format,omitempty"`
+	LdFlags        map[string]string      `json:"ldflags,omitempty"`
+	Stamp          []StampConfig          `json:"stamp,omitempty"`
+	Packages       []string               `json:"packages,omitempty"`
 }
 
+const DefaultDirtySuffix = "-dirty"
+
 type BranchConfig struct {
 	BranchPattern   string                 `json:"branch"`
 	VersionTemplate string                 `json:"version"`
 	Data            map[string]interface{} `json:"data,omitempty"`
 	DataFileFields  []string               `json:"data-file,omitempty"`
+	Latest          bool                   `json:"latest,omitempty"`
 }
 
 func (c *Config) HasData(name string) bool {
@@ -70,7 +82,8 @@ func (c *Config) writeConfig(filename string) error {
 	if err != nil {
 		return err
 	}
-	return ioutil.WriteFile(filename, data, 0664)
+	_, err = WriteFileIfChanged(filename, data, 0664)
+	return err
 }
 
 func readConfig(filename string) (*Config, error) {
@@ -86,11 +99,41 @@ func readConfig(filename string) (*Config, error) {
 	if len(config.Branches) == 0 {
 		return nil, errors.New("confing must contain at least one branch expressions")
 	}
+	if config.Rcs != "" && !IsRcsBackend(config.Rcs) {
+		return nil, fmt.Errorf("unknown rcs backend '%s'", config.Rcs)
+	}
+	if config.CiPrecedence != "" && !IsCiPrecedence(config.CiPrecedence) {
+		return nil, fmt.Errorf("unknown ci-precedence '%s'", config.CiPrecedence)
+	}
+	_, err = config.CiProviders()
+	if err != nil {
+		return nil, err
+	}
+	if config.DataFileFormat != "" && !IsDataFileFormat(config.DataFileFormat) {
+		return nil, fmt.Errorf("unknown data file format '%s'", config.DataFileFormat)
+	}
+	err = checkLdFlags(config.LdFlags)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStampConfig(config.Stamp)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range config.Packages {
+		if !IsPackageScheme(p) {
+			return nil, fmt.Errorf("unknown package scheme '%s'", p)
+		}
+	}
 	for _, bc := range config.Branches {
 		err := checkBranchConfig(bc)
 		if err != nil {
 			return nil, err
 		}
+		err = checkBranchTypes(&config, bc)
+		if err != nil {
+			return nil, err
+		}
 	}
 	return &config, nil
 }
@@ -108,7 +151,7 @@ func checkBranchConfig(bc BranchConfig) error {
 	}
 	t, err := ParseString(bc.VersionTemplate)
 	if err != nil {
-		return fmt.Errorf("version template '%s' is malformed", bc.VersionTemplate)
+		return fmt.Errorf("version template '%s' is malformed: %s", bc.VersionTemplate, err.Error())
 	}
 	err = ValidateTemplateAsVersion(t)
 	if err != nil {
@@ -117,6 +160,58 @@ func checkBranchConfig(bc BranchConfig) error {
 	return nil
 }
 
+// checkBranchTypes ensures that parameters used in arithmetic can be
+// integers, so that mistakes show up in test-config rather than when a
+// version is built.  Parameters with unknown types, such as those from
+// the command line, are assumed to be correct.
+func checkBranchTypes(c *Config, bc BranchConfig) error {
+	t, err := ParseString(bc.VersionTemplate)
+	if err != nil {
+		return err
+	}
+	for _, v := range t.IntegerVariables() {
+		pt := c.ParameterType(v, bc)
+		if pt != TYPE_UNKNOWN && pt != TYPE_INT {
+			return fmt.Errorf("version template '%s' uses %s in arithmetic but it is a %s", bc.VersionTemplate, v, pt)
+		}
+	}
+	return nil
+}
+
+// ParameterType determines a parameter's type following the same
+// precedence as LookupParameter.
+func (c *Config) ParameterType(name string, bc BranchConfig) string {
+	ptrn, err := regexp.Compile("^" + bc.BranchPattern + "$")
+	if err == nil {
+		for _, group := range ptrn.SubexpNames() {
+			if group != "" && group == strings.Replace(name, "-", "_", -1) {
+				return TYPE_UNKNOWN
+			}
+		}
+	}
+	pt, ok := ParameterTypes[name]
+	if ok {
+		return pt
+	}
+	v, ok := bc.Data[name]
+	if ok {
+		return DataType(v)
+	}
+	v, ok = c.Data[name]
+	if ok {
+		return DataType(v)
+	}
+	return TYPE_UNKNOWN
+}
+
+func DataType(v interface{}) string {
+	_, err := ParamDataToInt(v)
+	if err == nil {
+		return TYPE_INT
+	}
+	return TYPE_STRING
+}
+
 func (c *Config) getBranchConfig(branch string) (*BranchConfig, *map[string]string, error) {
 	for _, bc := range c.Branches {
 		ptrn := regexp.MustCompile("^" + bc.BranchPattern + "$")
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
 )
 
@@ -83,25 +86,116 @@ func LookupParameterWithoutMemoization(parameter string, c *Context) (string, er
 		return f(c)
 	}
 	// Now get defaults from the data sections.
-	v, ok := c.BranchConfig.Data[parameter]
-	if ok {
-		return ParamDataToString(v)
+	if c.BranchConfig != nil {
+		v, ok := c.BranchConfig.Data[parameter]
+		if ok {
+			return ParamDataToString(v)
+		}
 	}
 	// Finally we look for values supplied in the config's data section.
-	v, ok = c.Config.Data[parameter]
+	v, ok := c.Config.Data[parameter]
 	if ok {
 		return ParamDataToString(v)
 	}
-	return "", fmt.Errorf("unknown parameter %s", parameter)
+	return "", UnknownParameterError{Name: parameter}
+}
+
+type UnknownParameterError struct {
+	Name string
+}
+
+func (e UnknownParameterError) Error() string {
+	return fmt.Sprintf("unknown parameter %s", e.Name)
+}
+
+// UnavailableParameterError reports a known parameter that has no value
+// here, such as build-number outside of CI or last-tag in an untagged
+// repository.  Like an unknown parameter it can be given a default.
+type UnavailableParameterError struct {
+	Name string
+	Err  error
+}
+
+func (e UnavailableParameterError) Error() string {
+	return fmt.Sprintf("%s is not available: %s", e.Name, e.Err)
+}
+
+func (e UnavailableParameterError) Unwrap() error {
+	return e.Err
+}
+
+// unavailableWithoutTag marks the lack of a reachable tag as leaving
+// the parameter without a value, while other failures stay errors.
+func unavailableWithoutTag(name string, v string, err error) (string, error) {
+	var noTag NoTagError
+	if errors.As(err, &noTag) {
+		return "", UnavailableParameterError{Name: name, Err: err}
+	}
+	return v, err
+}
+
+// ParameterLookups is populated in init() because some lookups are
+// derived from other parameters through LookupParameter.
+var ParameterLookups map[string]func(c *Context) (string, error)
+
+func init() {
+	ParameterLookups = map[string]func(c *Context) (string, error){
+		"branch":            LookupBranch,
+		"commit-counter":    LookupCommitCounter,
+		"repo-counter":      LookupRepoCounter,
+		"commit-hash":       LookupCommitHash,
+		"commit-hash-short": LookupCommitHashShort,
+		"repo-root":         LookupRepoRoot,
+		"last-tag":          LookupLastTag,
+		"tag-distance":      LookupTagDistance,
+		"exact-tag":         LookupExactTag,
+		"tag-major":         LookupTagMajor,
+		"tag-minor":         LookupTagMinor,
+		"tag-release":       LookupTagRelease,
+		"dirty":             LookupDirty,
+		"dirty-suffix":      LookupDirtySuffix,
+		"commit-time":       LookupCommitTime,
+		"build-time":        LookupBuildTime,
+		"ci-provider":       LookupCiProvider,
+		"build-number":      LookupBuildNumber,
+		"pr-number":         LookupPrNumber,
+		"pr-source-branch":  LookupPrSourceBranch,
+		"pr-target-branch":  LookupPrTargetBranch,
+	}
 }
 
-var ParameterLookups = map[string]func(c *Context) (string, error){
-	"branch":            LookupBranch,
-	"commit-counter":    LookupCommitCounter,
-	"repo-counter":      LookupRepoCounter,
-	"commit-hash":       LookupCommitHash,
-	"commit-hash-short": LookupCommitHashShort,
-	"repo-root":         LookupRepoRoot,
+const (
+	TYPE_UNKNOWN = ""
+	TYPE_INT     = "integer"
+	TYPE_STRING  = "string"
+	TYPE_BOOL    = "boolean"
+	TYPE_TIME    = "time"
+)
+
+// ParameterTypes records the type of each calculated parameter for
+// checking templates before they are expanded.
+var ParameterTypes = map[string]string{
+	"branch":            TYPE_STRING,
+	"commit-counter":    TYPE_INT,
+	"repo-counter":      TYPE_INT,
+	"commit-hash":       TYPE_STRING,
+	"commit-hash-short": TYPE_STRING,
+	"repo-root":         TYPE_STRING,
+	"last-tag":          TYPE_STRING,
+	"tag-distance":      TYPE_INT,
+	"exact-tag":         TYPE_STRING,
+	"tag-major":         TYPE_INT,
+	"tag-minor":         TYPE_INT,
+	"tag-release":       TYPE_INT,
+	"dirty":             TYPE_BOOL,
+	"dirty-suffix":      TYPE_STRING,
+	"commit-time":       TYPE_TIME,
+	"build-time":        TYPE_TIME,
+	"ci-provider":       TYPE_STRING,
+	"build-number":      TYPE_INT,
+	"pr-number":         TYPE_STRING,
+	"pr-source-branch":  TYPE_STRING,
+	"pr-target-branch":  TYPE_STRING,
 }
 
 func LookupBranch(c *Context) (string, error) {
@@ -128,6 +222,155 @@ func LookupRepoRoot(c *Context) (string, error) {
 	return LookupFromRcs(c, func(r Rcs) (string, error) { return r.RepoRoot() })
 }
 
+func LookupLastTag(c *Context) (string, error) {
+	v, err := LookupFromRcs(c, func(r Rcs) (string, error) { return r.LastTag() })
+	return unavailableWithoutTag("last-tag", v, err)
+}
+
+func LookupTagDistance(c *Context) (string, error) {
+	v, err := LookupFromRcs(c, func(r Rcs) (string, error) { return r.TagDistance() })
+	return unavailableWithoutTag("tag-distance", v, err)
+}
+
+func LookupExactTag(c *Context) (string, error) {
+	return LookupFromRcs(c, func(r Rcs) (string, error) { return r.ExactTag() })
+}
+
+func LookupTagMajor(c *Context) (string, error) {
+	return LookupTagPart(c, 0)
+}
+
+func LookupTagMinor(c *Context) (string, error) {
+	return LookupTagPart(c, 1)
+}
+
+func LookupTagRelease(c *Context) (string, error) {
+	return LookupTagPart(c, 2)
+}
+
+func LookupTagPart(c *Context, i int) (string, error) {
+	// Go through LookupParameter so that an overridden last-tag
+	// is also reflected in its parts.
+	tag, err := LookupParameter("last-tag", c)
+	if err != nil {
+		return "", err
+	}
+	parts, err := ParseVersionTag(tag)
+	if err != nil {
+		return "", err
+	}
+	return parts[i], nil
+}
+
+// ParseVersionTag extracts the major, minor, and release numbers from
+// tags such as v1.4.2 or release-1.4.  Any non-numeric prefix is
+// ignored and a missing release number is treated as zero.
+func ParseVersionTag(tag string) ([]string, error) {
+	ptrn := regexp.MustCompile("^[^0-9]*([0-9]+)\\.([0-9]+)(?:\\.([0-9]+))?")
+	m := ptrn.FindStringSubmatch(tag)
+	if len(m) == 0 {
+		return nil, fmt.Errorf("tag '%s' does not contain a version number", tag)
+	}
+	if m[3] == "" {
+		m[3] = "0"
+	}
+	return m[1:], nil
+}
+
+func LookupDirty(c *Context) (string, error) {
+	return LookupFromRcs(c, func(r Rcs) (string, error) {
+		dirty, err := r.Dirty()
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(dirty), nil
+	})
+}
+
+func LookupDirtySuffix(c *Context) (string, error) {
+	v, err := LookupParameter("dirty", c)
+	if err != nil {
+		return "", err
+	}
+	dirty, err := strconv.ParseBool(v)
+	if err != nil {
+		return "", fmt.Errorf("cannot read dirty value '%s' as a boolean", v)
+	}
+	if !dirty {
+		return "", nil
+	}
+	if c.Config.DirtySuffix != "" {
+		return c.Config.DirtySuffix, nil
+	}
+	return DefaultDirtySuffix, nil
+}
+
+func LookupCommitTime(c *Context) (string, error) {
+	return LookupFromRcs(c, func(r Rcs) (string, error) {
+		t, err := r.CommitTime()
+		if err != nil {
+			return "", err
+		}
+		return FormatTimeParameter(t), nil
+	})
+}
+
+func LookupBuildTime(c *Context) (string, error) {
+	t, err := BuildTime()
+	if err != nil {
+		return "", err
+	}
+	return FormatTimeParameter(t), nil
+}
+
+// LookupCiProvider is empty when vers is not running under a recognized
+// CI system.
+func LookupCiProvider(c *Context) (string, error) {
+	return lookupFromCi(c, func(ci CiEnvironment) (string, error) { return ci.CiProvider(), nil })
+}
+
+func LookupBuildNumber(c *Context) (string, error) {
+	return LookupFromRcs(c, func(r Rcs) (string, error) {
+		ci, ok := r.(CiEnvironment)
+		if !ok {
+			return "", UnavailableParameterError{
+				Name: "build-number",
+				Err:  errors.New("only available under a recognized CI system"),
+			}
+		}
+		n, err := ci.BuildNumber()
+		if err != nil {
+			return "", UnavailableParameterError{Name: "build-number", Err: err}
+		}
+		return n, nil
+	})
+}
+
+// LookupPrNumber is empty outside of pull request builds, as are the
+// pull request branches.
+func LookupPrNumber(c *Context) (string, error) {
+	return lookupFromCi(c, func(ci CiEnvironment) (string, error) { return ci.PrNumber() })
+}
+
+func LookupPrSourceBranch(c *Context) (string, error) {
+	return lookupFromCi(c, func(ci CiEnvironment) (string, error) { return ci.PrSourceBranch() })
+}
+
+func LookupPrTargetBranch(c *Context) (string, error) {
+	return lookupFromCi(c, func(ci CiEnvironment) (string, error) { return ci.PrTargetBranch() })
+}
+
+// lookupFromCi looks up a value that is empty when not running under CI.
+func lookupFromCi(c *Context, f func(CiEnvironment) (string, error)) (string, error) {
+	return LookupFromRcs(c, func(r Rcs) (string, error) {
+		ci, ok := r.(CiEnvironment)
+		if !ok {
+			return "", nil
+		}
+		return f(ci)
+	})
+}
+
 func LookupFromRcs(c *Context, f func(Rcs) (string, error)) (string, error) {
 	rcs, err := c.GetRcs()
 	if err != nil {
@@ -144,7 +387,7 @@ func (c *Context) GetRcs() (Rcs, error) {
 	if c.Rcs != nil {
 		return c.Rcs, nil
 	}
-	rcs, err := GetRcs(c.VersionFile)
+	rcs, err := GetRcs(c.VersionFile, &c.Config)
 	if err != nil {
 		return nil, err
 	}
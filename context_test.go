@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseVersionTag(t *testing.T) {
+	var cases = []struct {
+		Tag     string
+		Major   string
+		Minor   string
+		Release string
+	}{
+		{"1.4.2", "1", "4", "2"},
+		{"v1.4.2", "1", "4", "2"},
+		{"release-10.0", "10", "0", "0"},
+		{"v2.3.4-rc1", "2", "3", "4"},
+	}
+	for _, tc := range cases {
+		parts, err := ParseVersionTag(tc.Tag)
+		failWhenErr(t, err)
+		failWhen(t, parts[0] != tc.Major)
+		failWhen(t, parts[1] != tc.Minor)
+		failWhen(t, parts[2] != tc.Release)
+	}
+}
+
+func TestParseVersionTagWithoutVersion(t *testing.T) {
+	_, err := ParseVersionTag("stable")
+	failWhen(t, err == nil)
+}
+
+func TestTagPartsFollowOverriddenTag(t *testing.T) {
+	ctx := Context{
+		State: map[string]string{"last-tag": "v3.1.4"},
+	}
+	v, err := LookupParameter("tag-minor", &ctx)
+	failWhenErr(t, err)
+	failWhen(t, v != "1")
+}
+
+func TestDirtySuffix(t *testing.T) {
+	var cases = []struct {
+		Dirty  string
+		Suffix string
+		Want   string
+	}{
+		{"false", "", ""},
+		{"true", "", "-dirty"},
+		{"true", ".modified", ".modified"},
+		{"false", ".modified", ""},
+	}
+	for _, tc := range cases {
+		ctx := Context{
+			State:  map[string]string{"dirty": tc.Dirty},
+			Config: Config{DirtySuffix: tc.Suffix},
+		}
+		v, err := LookupParameter("dirty-suffix", &ctx)
+		failWhenErr(t, err)
+		failWhen(t, v != tc.Want)
+	}
+}
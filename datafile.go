@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf16"
+)
+
+// DataFileWriter renders data file fields in one output format.
+type DataFileWriter func(data map[string]string) ([]byte, error)
+
+const DefaultDataFileFormat = "json"
+
+// DataFileWriters maps each format name accepted by data-file --format and
+// the data-file-format setting to its writer.
+var DataFileWriters = map[string]DataFileWriter{
+	"json":       FormatJsonDataFile,
+	"env":        FormatEnvDataFile,
+	"yaml":       FormatYamlDataFile,
+	"toml":       FormatTomlDataFile,
+	"properties": FormatPropertiesDataFile,
+	"make":       FormatMakeDataFile,
+
+	"github-output": FormatGithubOutputDataFile,
+	"github-env":    FormatGithubEnvDataFile,
+	"gitlab-dotenv": FormatGitlabDotenvDataFile,
+}
+
+// DataFileTargets names the environment variable holding the default
+// output file for formats that feed a CI system.  CI systems share these
+// files between steps, so they are appended to rather than replaced.
+var DataFileTargets = map[string]string{
+	"github-output": "GITHUB_OUTPUT",
+	"github-env":    "GITHUB_ENV",
+}
+
+func IsDataFileFormat(format string) bool {
+	_, ok := DataFileWriters[format]
+	return ok
+}
+
+func DataFileFormats() []string {
+	formats := []string{}
+	for f := range DataFileWriters {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+func FormatDataFile(format string, data map[string]string) ([]byte, error) {
+	w, ok := DataFileWriters[format]
+	if !ok {
+		return nil, fmt.Errorf("unknown data file format '%s'", format)
+	}
+	return w(data)
+}
+
+// writeDataFile writes the data file and reports whether it changed.
+// Appending always changes the file.
+func writeDataFile(filename string, format string, data map[string]string) (bool, error) {
+	out, err := FormatDataFile(format, data)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := DataFileTargets[format]; !ok {
+		return WriteFileIfChanged(filename, out, 0664)
+	}
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0664)
+	if err != nil {
+		return false, err
+	}
+	_, err = f.Write(out)
+	if err != nil {
+		f.Close()
+		return false, err
+	}
+	return true, f.Close()
+}
+
+func sortedDataKeys(data map[string]string) []string {
+	keys := []string{}
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// FormatJsonDataFile writes JSON data files exactly as vers always has,
+// without a trailing newline.
+func FormatJsonDataFile(data map[string]string) ([]byte, error) {
+	return json.MarshalIndent(data, "", "  ")
+}
+
+var nonIdentPtrn = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// MakeVariableName turns a parameter name such as commit-counter into
+// COMMIT_COUNTER, which both shells and make accept as a variable name.
+func MakeVariableName(name string) string {
+	v := strings.ToUpper(nonIdentPtrn.ReplaceAllString(name, "_"))
+	if v == "" || (v[0] >= '0' && v[0] <= '9') {
+		v = "_" + v
+	}
+	return v
+}
+
+// variableNames maps each field to its variable name, refusing fields
+// that collide once converted.
+func variableNames(data map[string]string) (map[string]string, error) {
+	names := map[string]string{}
+	seen := map[string]string{}
+	for _, k := range sortedDataKeys(data) {
+		v := MakeVariableName(k)
+		if other, ok := seen[v]; ok {
+			return nil, fmt.Errorf("fields '%s' and '%s' both become variable %s", other, k, v)
+		}
+		seen[v] = k
+		names[k] = v
+	}
+	return names, nil
+}
+
+// FormatEnvDataFile writes NAME='value' lines that a POSIX shell can
+// source.  Single quotes suppress every expansion, so the only character
+// needing care is the single quote itself.
+func FormatEnvDataFile(data map[string]string) ([]byte, error) {
+	names, err := variableNames(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		fmt.Fprintf(&b, "%s='%s'\n", names[k], strings.Replace(data[k], "'", `'\''`, -1))
+	}
+	return b.Bytes(), nil
+}
+
+// FormatMakeDataFile writes simply expanded variables for inclusion in a
+// Makefile.  Make has no way to express a newline in a one line
+// assignment, so such values are rejected.
+func FormatMakeDataFile(data map[string]string) ([]byte, error) {
+	names, err := variableNames(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		v := data[k]
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("field '%s' contains a newline, which make cannot represent", k)
+		}
+		if strings.HasSuffix(v, `\`) {
+			return nil, fmt.Errorf("field '%s' ends with a backslash, which make reads as a line continuation", k)
+		}
+		v = strings.Replace(v, "$", "$$", -1)
+		v = strings.Replace(v, "#", `\#`, -1)
+		fmt.Fprintf(&b, "%s := %s\n", names[k], v)
+	}
+	return b.Bytes(), nil
+}
+
+var yamlPlainKeyPtrn = regexp.MustCompile("^[A-Za-z_][A-Za-z0-9_-]*$")
+
+// quoteDoubleQuoted produces a double quoted string using the escapes
+// that YAML and TOML share.
+func quoteDoubleQuoted(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\r':
+			b.WriteString(`\r`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u%04X`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// FormatYamlDataFile writes a flat mapping.  Values are always double
+// quoted so that strings such as "yes", "1.10", or "null" stay strings.
+func FormatYamlDataFile(data map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		key := k
+		if !yamlPlainKeyPtrn.MatchString(k) {
+			key = quoteDoubleQuoted(k)
+		}
+		fmt.Fprintf(&b, "%s: %s\n", key, quoteDoubleQuoted(data[k]))
+	}
+	return b.Bytes(), nil
+}
+
+var tomlBareKeyPtrn = regexp.MustCompile("^[A-Za-z0-9_-]+$")
+
+func FormatTomlDataFile(data map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		key := k
+		if !tomlBareKeyPtrn.MatchString(k) {
+			key = quoteDoubleQuoted(k)
+		}
+		fmt.Fprintf(&b, "%s = %s\n", key, quoteDoubleQuoted(data[k]))
+	}
+	return b.Bytes(), nil
+}
+
+// escapeProperty applies java.util.Properties escaping.  Properties files
+// are read as ISO-8859-1, so everything outside printable ASCII becomes a
+// \uXXXX escape.
+func escapeProperty(s string, isKey bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch {
+		case r == '\\':
+			b.WriteString(`\\`)
+		case r == '\t':
+			b.WriteString(`\t`)
+		case r == '\n':
+			b.WriteString(`\n`)
+		case r == '\r':
+			b.WriteString(`\r`)
+		case r == '\f':
+			b.WriteString(`\f`)
+		case r == ' ' && (isKey || i == 0):
+			b.WriteString(`\ `)
+		case strings.ContainsRune("=:#!", r):
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 0x20 || r > 0x7e:
+			// Characters outside the BMP become surrogate pairs.
+			for _, u := range utf16.Encode([]rune{r}) {
+				fmt.Fprintf(&b, `\u%04X`, u)
+			}
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func FormatPropertiesDataFile(data map[string]string) ([]byte, error) {
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		fmt.Fprintf(&b, "%s=%s\n", escapeProperty(k, true), escapeProperty(data[k], false))
+	}
+	return b.Bytes(), nil
+}
+
+// githubDelimiter picks a random heredoc delimiter that appears in none of
+// the values, as GitHub's own toolkit does.
+func githubDelimiter(data map[string]string) (string, error) {
+	for {
+		b := make([]byte, 16)
+		_, err := rand.Read(b)
+		if err != nil {
+			return "", err
+		}
+		delim := "ghadelimiter_" + hex.EncodeToString(b)
+		clash := false
+		for _, v := range data {
+			if strings.Contains(v, delim) {
+				clash = true
+			}
+		}
+		if !clash {
+			return delim, nil
+		}
+	}
+}
+
+// formatGithubFile writes name<<delimiter blocks, which GitHub Actions
+// reads from both $GITHUB_OUTPUT and $GITHUB_ENV and which carry
+// multiline values intact.
+func formatGithubFile(data map[string]string, names map[string]string) ([]byte, error) {
+	delim, err := githubDelimiter(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		fmt.Fprintf(&b, "%s<<%s\n%s\n%s\n", names[k], delim, data[k], delim)
+	}
+	return b.Bytes(), nil
+}
+
+// FormatGithubOutputDataFile writes step outputs.  Output names keep the
+// field names, so commit-hash is steps.<id>.outputs.commit-hash.
+func FormatGithubOutputDataFile(data map[string]string) ([]byte, error) {
+	names := map[string]string{}
+	for k := range data {
+		names[k] = k
+	}
+	return formatGithubFile(data, names)
+}
+
+// FormatGithubEnvDataFile writes environment variables for later steps.
+func FormatGithubEnvDataFile(data map[string]string) ([]byte, error) {
+	names, err := variableNames(data)
+	if err != nil {
+		return nil, err
+	}
+	return formatGithubFile(data, names)
+}
+
+// FormatGitlabDotenvDataFile writes a GitLab dotenv report.  GitLab reads
+// values literally up to the end of the line and has no multiline
+// syntax, so such values are rejected.
+func FormatGitlabDotenvDataFile(data map[string]string) ([]byte, error) {
+	names, err := variableNames(data)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	for _, k := range sortedDataKeys(data) {
+		v := data[k]
+		if strings.ContainsAny(v, "\r\n") {
+			return nil, fmt.Errorf("field '%s' contains a newline, which GitLab dotenv reports cannot represent", k)
+		}
+		fmt.Fprintf(&b, "%s=%s\n", names[k], v)
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var dataFileFormatTests = []struct {
+	Format string
+	Data   map[string]string
+	Output string
+}{
+	{"json", map[string]string{"version": "1.0", "branch": "master"},
+		"{\n  \"branch\": \"master\",\n  \"version\": \"1.0\"\n}"},
+	{"env", map[string]string{"version": "1.0", "commit-counter": "12"},
+		"COMMIT_COUNTER='12'\nVERSION='1.0'\n"},
+	{"env", map[string]string{"x": "it's $HOME `ls`"},
+		"X='it'\\''s $HOME `ls`'\n"},
+	{"env", map[string]string{"x": "a\nb"},
+		"X='a\nb'\n"},
+	{"env", map[string]string{"2x": "a"},
+		"_2X='a'\n"},
+	{"yaml", map[string]string{"version": "1.10", "commit-counter": "12"},
+		"commit-counter: \"12\"\nversion: \"1.10\"\n"},
+	{"yaml", map[string]string{"x": "say \"hi\"\\\n\t\x01"},
+		"x: \"say \\\"hi\\\"\\\\\\n\\t\\u0001\"\n"},
+	{"yaml", map[string]string{"x y": "yes"},
+		"\"x y\": \"yes\"\n"},
+	{"toml", map[string]string{"version": "1.0", "commit-counter": "12"},
+		"commit-counter = \"12\"\nversion = \"1.0\"\n"},
+	{"toml", map[string]string{"x": "say \"hi\"\\\r\n"},
+		"x = \"say \\\"hi\\\"\\\\\\r\\n\"\n"},
+	{"toml", map[string]string{"a.b": "c"},
+		"\"a.b\" = \"c\"\n"},
+	{"properties", map[string]string{"version": "1.0", "commit-counter": "12"},
+		"commit-counter=12\nversion=1.0\n"},
+	{"properties", map[string]string{"a b=c": " x=y:z\\\n"},
+		"a\\ b\\=c=\\ x\\=y\\:z\\\\\\n\n"},
+	{"properties", map[string]string{"x": "#!é😀"},
+		"x=\\#\\!\\u00E9\\uD83D\\uDE00\n"},
+	{"make", map[string]string{"version": "1.0", "commit-counter": "12"},
+		"COMMIT_COUNTER := 12\nVERSION := 1.0\n"},
+	{"make", map[string]string{"x": "$(HOME) #1"},
+		"X := $$(HOME) \\#1\n"},
+}
+
+func TestFormatDataFile(t *testing.T) {
+	for _, tc := range dataFileFormatTests {
+		out, err := FormatDataFile(tc.Format, tc.Data)
+		failWhenErr(t, err)
+		if string(out) != tc.Output {
+			t.Errorf("%s: wanted %q but got %q", tc.Format, tc.Output, string(out))
+		}
+	}
+}
+
+var dataFileFormatErrorTests = []struct {
+	Format string
+	Data   map[string]string
+}{
+	{"xml", map[string]string{"x": "1"}},
+	{"env", map[string]string{"a-b": "1", "a_b": "2"}},
+	{"make", map[string]string{"x": "a\nb"}},
+	{"make", map[string]string{"x": "a\\"}},
+	{"make", map[string]string{"a.b": "1", "a-b": "2"}},
+}
+
+func TestFormatDataFileErrors(t *testing.T) {
+	for _, tc := range dataFileFormatErrorTests {
+		_, err := FormatDataFile(tc.Format, tc.Data)
+		if err == nil {
+			t.Errorf("%s: expected error for %v", tc.Format, tc.Data)
+		}
+	}
+}
+
+func TestEnvDataFileSurvivesShell(t *testing.T) {
+	skipWithoutCommand(t, "sh")
+	value := "it's \"$HOME\" `ls` \\n\nline"
+	out, err := FormatEnvDataFile(map[string]string{"x": value})
+	failWhenErr(t, err)
+	got, err := exec.Command("sh", "-c", string(out)+"printf %s \"$X\"").Output()
+	failWhenErr(t, err)
+	if string(got) != value {
+		t.Errorf("wanted %q but got %q", value, string(got))
+	}
+}
+
+func TestUnknownDataFileFormatIsInvalid(t *testing.T) {
+	tf, err := ioutil.TempFile("", "version.json")
+	failWhenErr(t, err)
+	defer os.Remove(tf.Name())
+	c := Config{
+		Branches: []BranchConfig{{
+			BranchPattern:   ".*",
+			VersionTemplate: "{branch}",
+		},
+		},
+		DataFileFormat: "xml",
+	}
+	failWhenErr(t, c.writeConfig(tf.Name()))
+	_, err = readConfig(tf.Name())
+	failWhen(t, err == nil)
+}
+
+// parseGithubFile reads name<<delimiter blocks the way GitHub Actions
+// does.
+func parseGithubFile(t *testing.T, content string) map[string]string {
+	res := map[string]string{}
+	lines := strings.Split(content, "\n")
+	for i := 0; i < len(lines); i++ {
+		if lines[i] == "" {
+			continue
+		}
+		parts := strings.SplitN(lines[i], "<<", 2)
+		if len(parts) != 2 {
+			t.Fatalf("malformed line %q", lines[i])
+		}
+		value := []string{}
+		for i++; i < len(lines) && lines[i] != parts[1]; i++ {
+			value = append(value, lines[i])
+		}
+		if i == len(lines) {
+			t.Fatalf("unterminated value for %s", parts[0])
+		}
+		res[parts[0]] = strings.Join(value, "\n")
+	}
+	return res
+}
+
+func TestGithubDataFilesKeepMultilineValues(t *testing.T) {
+	data := map[string]string{
+		"commit-hash": "abc",
+		"notes":       "line one\nEOF\nghadelimiter_\n\nline five",
+	}
+	out, err := FormatGithubOutputDataFile(data)
+	failWhenErr(t, err)
+	if got := parseGithubFile(t, string(out)); !reflect.DeepEqual(got, data) {
+		t.Errorf("wanted %v but got %v", data, got)
+	}
+	out, err = FormatGithubEnvDataFile(data)
+	failWhenErr(t, err)
+	expected := map[string]string{
+		"COMMIT_HASH": data["commit-hash"],
+		"NOTES":       data["notes"],
+	}
+	if got := parseGithubFile(t, string(out)); !reflect.DeepEqual(got, expected) {
+		t.Errorf("wanted %v but got %v", expected, got)
+	}
+}
+
+func TestGithubDataFileAppends(t *testing.T) {
+	tf, err := ioutil.TempFile("", "github-output")
+	failWhenErr(t, err)
+	defer os.Remove(tf.Name())
+	tf.WriteString("earlier=step\n")
+	tf.Close()
+	changed, err := writeDataFile(tf.Name(), "github-output", map[string]string{"version": "1.0"})
+	failWhenErr(t, err)
+	failWhen(t, !changed)
+	out, err := ioutil.ReadFile(tf.Name())
+	failWhenErr(t, err)
+	failWhen(t, !strings.HasPrefix(string(out), "earlier=step\nversion<<ghadelimiter_"))
+}
+
+func TestGitlabDotenvDataFile(t *testing.T) {
+	out, err := FormatGitlabDotenvDataFile(map[string]string{"commit-hash": "abc", "note": "a 'b' \"c\""})
+	failWhenErr(t, err)
+	failWhen(t, string(out) != "COMMIT_HASH=abc\nNOTE=a 'b' \"c\"\n")
+	_, err = FormatGitlabDotenvDataFile(map[string]string{"note": "a\nb"})
+	failWhen(t, err == nil)
+}
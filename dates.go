@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time parameters are carried around as RFC 3339 strings in UTC so that
+// they read naturally in data files and sort lexically.
+func FormatTimeParameter(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// ParseTimeParameter accepts RFC 3339 timestamps and unix times, which
+// makes it easy to supply times with -X or from the environment.
+func ParseTimeParameter(v string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, v)
+	if err == nil {
+		return t.UTC(), nil
+	}
+	secs, err := strconv.ParseInt(v, 10, 64)
+	if err == nil {
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("could not read '%s' as a time", v)
+}
+
+// BuildTime honors SOURCE_DATE_EPOCH so that reproducible builds get
+// the same build time on every run.
+func BuildTime() (time.Time, error) {
+	sde, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	if !ok || sde == "" {
+		return time.Now().UTC(), nil
+	}
+	secs, err := strconv.ParseInt(sde, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("SOURCE_DATE_EPOCH '%s' is not a unix time", sde)
+	}
+	return time.Unix(secs, 0).UTC(), nil
+}
+
+// StrftimeDirectives lists the supported conversions.  Each may be
+// written as %-X to drop zero padding.
+const StrftimeDirectives = "YymdHMSjsGV"
+
+func IsStrftimeDirective(r rune) bool {
+	return strings.ContainsRune(StrftimeDirectives, r)
+}
+
+// Strftime formats a time using a subset of the C strftime conversions.
+func Strftime(t time.Time, format string) (string, error) {
+	res := ""
+	rs := []rune(format)
+	for i := 0; i < len(rs); i++ {
+		if rs[i] != '%' {
+			res = res + string(rs[i])
+			continue
+		}
+		i++
+		if i < len(rs) && rs[i] == '%' {
+			res = res + "%"
+			continue
+		}
+		pad := true
+		if i < len(rs) && rs[i] == '-' {
+			pad = false
+			i++
+		}
+		if i >= len(rs) {
+			return "", fmt.Errorf("date format '%s' ends with an incomplete directive", format)
+		}
+		year, week := t.ISOWeek()
+		var n, width int
+		switch rs[i] {
+		case 'Y':
+			n, width = t.Year(), 4
+		case 'y':
+			n, width = t.Year()%100, 2
+		case 'm':
+			n, width = int(t.Month()), 2
+		case 'd':
+			n, width = t.Day(), 2
+		case 'H':
+			n, width = t.Hour(), 2
+		case 'M':
+			n, width = t.Minute(), 2
+		case 'S':
+			n, width = t.Second(), 2
+		case 'j':
+			n, width = t.YearDay(), 3
+		case 's':
+			n, width = int(t.Unix()), 1
+		case 'G':
+			n, width = year, 4
+		case 'V':
+			n, width = week, 2
+		default:
+			return "", fmt.Errorf("unknown date directive '%%%c'", rs[i])
+		}
+		if !pad {
+			width = 1
+		}
+		res = res + fmt.Sprintf("%0*d", width, n)
+	}
+	return res, nil
+}
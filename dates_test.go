@@ -0,0 +1,56 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStrftime(t *testing.T) {
+	tm := time.Date(2026, time.January, 5, 7, 8, 9, 0, time.UTC)
+	var cases = []struct {
+		Format string
+		Want   string
+	}{
+		{"%Y.%m.%d", "2026.01.05"},
+		{"%Y.%-m.%-d", "2026.1.5"},
+		{"%y%m%d%H%M%S", "260105070809"},
+		{"%j", "005"},
+		{"%G-W%V", "2026-W02"},
+		{"%s", "1767596889"},
+		{"100%%", "100%"},
+	}
+	for _, tc := range cases {
+		x, err := Strftime(tm, tc.Format)
+		failWhenErr(t, err)
+		if x != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Format, tc.Want, x)
+		}
+	}
+}
+
+func TestStrftimeMalformed(t *testing.T) {
+	for _, f := range []string{"%", "%-", "%Q"} {
+		_, err := Strftime(time.Now(), f)
+		failWhen(t, err == nil)
+	}
+}
+
+func TestParseTimeParameter(t *testing.T) {
+	for _, v := range []string{"2017-07-14T02:40:00Z", "2017-07-14T04:40:00+02:00", "1500000000"} {
+		tm, err := ParseTimeParameter(v)
+		failWhenErr(t, err)
+		failWhen(t, tm.Unix() != 1500000000)
+	}
+	_, err := ParseTimeParameter("yesterday")
+	failWhen(t, err == nil)
+}
+
+func TestBuildTimeHonorsSourceDateEpoch(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+	ctx := Context{
+		State: map[string]string{},
+	}
+	v, err := LookupParameter("build-time", &ctx)
+	failWhenErr(t, err)
+	failWhen(t, v != "2017-07-14T02:40:00Z")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Expr is an integer arithmetic expression over parameters and literals.
+type Expr interface {
+	Eval(c *Context) (int, error)
+	Vars() []string
+}
+
+type ExprNumber struct {
+	Value int
+}
+
+func (e ExprNumber) Eval(c *Context) (int, error) {
+	return e.Value, nil
+}
+
+func (e ExprNumber) Vars() []string {
+	return []string{}
+}
+
+type ExprVar struct {
+	Name string
+}
+
+func (e ExprVar) Eval(c *Context) (int, error) {
+	value, err := LookupParameter(e.Name, c)
+	if err != nil {
+		return 0, ExpansionError{Name: e.Name, Err: err}
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("could not read %s value '%s' as integer", e.Name, value)
+	}
+	return n, nil
+}
+
+func (e ExprVar) Vars() []string {
+	return []string{e.Name}
+}
+
+type ExprNegate struct {
+	X Expr
+}
+
+func (e ExprNegate) Eval(c *Context) (int, error) {
+	x, err := e.X.Eval(c)
+	if err != nil {
+		return 0, err
+	}
+	return -x, nil
+}
+
+func (e ExprNegate) Vars() []string {
+	return e.X.Vars()
+}
+
+type ExprBinary struct {
+	Op    rune
+	Left  Expr
+	Right Expr
+}
+
+func (e ExprBinary) Eval(c *Context) (int, error) {
+	l, err := e.Left.Eval(c)
+	if err != nil {
+		return 0, err
+	}
+	r, err := e.Right.Eval(c)
+	if err != nil {
+		return 0, err
+	}
+	switch e.Op {
+	case '+':
+		return l + r, nil
+	case '-':
+		return l - r, nil
+	case '*':
+		return l * r, nil
+	case '/':
+		if r == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return l / r, nil
+	case '%':
+		if r == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return l % r, nil
+	default:
+		panic("unknown operator")
+	}
+}
+
+func (e ExprBinary) Vars() []string {
+	return append(e.Left.Vars(), e.Right.Vars()...)
+}
+
+// ParseExpr parses expressions such as "commit-counter - 1200".  Since
+// parameter names may contain '-', subtraction following a name needs a
+// space before the operator.
+func ParseExpr(src string) (Expr, error) {
+	p := exprParser{src: []rune(src)}
+	e, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.src) {
+		return nil, p.errorf("unexpected '%c'", p.src[p.pos])
+	}
+	return e, nil
+}
+
+type exprParser struct {
+	src []rune
+	pos int
+}
+
+func (p *exprParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("%s in expression '%s'", fmt.Sprintf(format, args...), string(p.src))
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *exprParser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) parseSum() (Expr, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '+' && op != '-' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = ExprBinary{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *exprParser) parseProduct() (Expr, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op := p.peek()
+		if op != '*' && op != '/' && op != '%' {
+			return left, nil
+		}
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		if n, ok := right.(ExprNumber); ok && n.Value == 0 && op != '*' {
+			return nil, p.errorf("division by zero")
+		}
+		left = ExprBinary{Op: op, Left: left, Right: right}
+	}
+}
+
+func (p *exprParser) parseFactor() (Expr, error) {
+	r := p.peek()
+	if r == 0 {
+		return nil, p.errorf("unexpected end")
+	} else if r == '(' {
+		p.pos++
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing ')'")
+		}
+		p.pos++
+		return e, nil
+	} else if r == '-' {
+		p.pos++
+		x, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		return ExprNegate{X: x}, nil
+	} else if unicode.IsDigit(r) {
+		start := p.pos
+		for p.pos < len(p.src) && unicode.IsDigit(p.src[p.pos]) {
+			p.pos++
+		}
+		n, err := strconv.Atoi(string(p.src[start:p.pos]))
+		if err != nil {
+			return nil, p.errorf("number %s out of range", string(p.src[start:p.pos]))
+		}
+		return ExprNumber{Value: n}, nil
+	} else if unicode.IsLetter(r) {
+		start := p.pos
+		for p.pos < len(p.src) && (unicode.IsLetter(p.src[p.pos]) || unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '-') {
+			p.pos++
+		}
+		return ExprVar{Name: string(p.src[start:p.pos])}, nil
+	}
+	return nil, p.errorf("unexpected '%c'", r)
+}
+
+// ExpressionNode expands an arithmetic expression, optionally zero
+// filled to a fixed width.
+type ExpressionNode struct {
+	Expr       Expr
+	FieldWidth int
+}
+
+func NewExpressionNode(varExpr string) (TemplateNode, error) {
+	src := varExpr
+	width := 0
+	for i, r := range varExpr {
+		if r == ':' {
+			// The tokenizer only lets zero fill specifiers through.
+			src = varExpr[0:i]
+			w, err := strconv.Atoi(string(varExpr[i+2]))
+			if err != nil {
+				panic("run specifier must be a digit")
+			}
+			width = w
+			break
+		}
+	}
+	e, err := ParseExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	return &ExpressionNode{Expr: e, FieldWidth: width}, nil
+}
+
+func (n ExpressionNode) Expand(c *Context) (string, error) {
+	v, err := n.Expr.Eval(c)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", n.FieldWidth, v), nil
+}
+
+func (n ExpressionNode) Vars() []string {
+	return n.Expr.Vars()
+}
+
+func (n ExpressionNode) IntVars() []string {
+	return n.Expr.Vars()
+}
@@ -1,10 +1,14 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
+	"strconv"
 )
 
 func FindInPath(f func(string) (bool, error), path string) (string, error) {
@@ -34,10 +38,22 @@ func _FindInPath(f func(string) (bool, error), path string) (string, error) {
 	return _FindInPath(f, dn)
 }
 
+// RcsMarkers are the files and directories that mark a checkout's root.
+// Fossil calls its checkout database .fslckout, or _FOSSIL_ on Windows
+// and in older checkouts.
+var RcsMarkers = map[string]bool{
+	".git":      true,
+	".svn":      true,
+	".hg":       true,
+	".jj":       true,
+	".fslckout": true,
+	"_FOSSIL_":  true,
+}
+
 func IsRcsDir(path string) (bool, error) {
 	return DirHasSatisfyingFile(
 		func(fi os.FileInfo) bool {
-			return fi.Name() == ".git" || fi.Name() == ".svn"
+			return RcsMarkers[fi.Name()]
 		},
 		path)
 }
@@ -62,3 +78,73 @@ func DirHasSatisfyingFile(f func(os.FileInfo) bool, path string) (bool, error) {
 	}
 	return false, nil
 }
+
+// createTempFile works like ioutil.TempFile but creates the file with
+// perm, to which the umask applies just as with ioutil.WriteFile.
+func createTempFile(dir string, prefix string, perm os.FileMode) (*os.File, error) {
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10))
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, fmt.Errorf("could not create a temporary file in %s", dir)
+}
+
+// WriteFileIfChanged replaces a file's contents unless they already match,
+// so that build tools watching modification times see no change.  The new
+// contents go to a temporary file in the same directory which is then
+// renamed into place, so readers never see a partial file.  An existing
+// file keeps its permissions, and a symlink is followed so that its
+// target is replaced rather than the link.  The result reports whether
+// the file was written.
+func WriteFileIfChanged(filename string, data []byte, perm os.FileMode) (bool, error) {
+	target, err := filepath.EvalSymlinks(filename)
+	if err == nil {
+		filename = target
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	fi, err := os.Stat(filename)
+	exists := err == nil
+	if exists {
+		perm = fi.Mode().Perm()
+		old, err := ioutil.ReadFile(filename)
+		if err == nil && bytes.Equal(old, data) {
+			return false, nil
+		}
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tf, err := createTempFile(dir, "."+base+".tmp", perm)
+	if err != nil {
+		return false, err
+	}
+	// Once renamed the temporary file is gone and this does nothing.
+	defer os.Remove(tf.Name())
+	_, err = tf.Write(data)
+	if err == nil {
+		err = tf.Sync()
+	}
+	if cerr := tf.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && exists {
+		// The umask may have stripped bits that the file already had.
+		err = os.Chmod(tf.Name(), perm)
+	}
+	if err == nil {
+		err = os.Rename(tf.Name(), filename)
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NewFilterNode wraps a node with the filter described by spec.  Filters
+// are applied left to right, so each one wraps the filters before it.
+func NewFilterNode(node TemplateNode, spec FilterSpec) (TemplateNode, error) {
+	switch spec.Name {
+	case "lower":
+		err := checkFilterArgs(spec, 0, 0)
+		return &LowerFilterNode{Node: node}, err
+	case "upper":
+		err := checkFilterArgs(spec, 0, 0)
+		return &UpperFilterNode{Node: node}, err
+	case "slug":
+		err := checkFilterArgs(spec, 0, 1)
+		sep := "-"
+		if len(spec.Args) == 1 {
+			sep = spec.Args[0]
+		}
+		return &SlugFilterNode{Node: node, Separator: sep}, err
+	case "trunc":
+		err := checkFilterArgs(spec, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		width, err := strconv.Atoi(spec.Args[0])
+		if err != nil || width < 0 {
+			return nil, fmt.Errorf("filter trunc at position %d expects a non-negative integer but got '%s'", spec.Pos, spec.Args[0])
+		}
+		return &TruncFilterNode{Node: node, Width: width}, nil
+	case "replace":
+		err := checkFilterArgs(spec, 2, 2)
+		if err != nil {
+			return nil, err
+		}
+		if spec.Args[0] == "" {
+			return nil, fmt.Errorf("filter replace at position %d cannot replace an empty string", spec.Pos)
+		}
+		return &ReplaceFilterNode{Node: node, Old: spec.Args[0], New: spec.Args[1]}, nil
+	case "default":
+		err := checkFilterArgs(spec, 1, 1)
+		if err != nil {
+			return nil, err
+		}
+		return &DefaultFilterNode{Node: node, Value: spec.Args[0]}, nil
+	default:
+		return nil, fmt.Errorf("unknown filter '%s' at position %d", spec.Name, spec.Pos)
+	}
+}
+
+func checkFilterArgs(spec FilterSpec, min int, max int) error {
+	n := len(spec.Args)
+	if n >= min && n <= max {
+		return nil
+	}
+	if min == max {
+		return fmt.Errorf("filter %s at position %d expects %d arguments but got %d", spec.Name, spec.Pos, min, n)
+	}
+	return fmt.Errorf("filter %s at position %d expects %d to %d arguments but got %d", spec.Name, spec.Pos, min, max, n)
+}
+
+type LowerFilterNode struct {
+	Node TemplateNode
+}
+
+func (n LowerFilterNode) Expand(c *Context) (string, error) {
+	v, err := n.Node.Expand(c)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToLower(v), nil
+}
+
+func (n LowerFilterNode) Vars() []string {
+	return n.Node.Vars()
+}
+
+func (n LowerFilterNode) IntVars() []string {
+	return n.Node.IntVars()
+}
+
+type UpperFilterNode struct {
+	Node TemplateNode
+}
+
+func (n UpperFilterNode) Expand(c *Context) (string, error) {
+	v, err := n.Node.Expand(c)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(v), nil
+}
+
+func (n UpperFilterNode) Vars() []string {
+	return n.Node.Vars()
+}
+
+func (n UpperFilterNode) IntVars() []string {
+	return n.Node.IntVars()
+}
+
+// SlugFilterNode reduces a value to ASCII letters and digits, replacing
+// each run of anything else with the separator.  The result is safe in
+// file names, Docker tags, and package versions.
+type SlugFilterNode struct {
+	Node      TemplateNode
+	Separator string
+}
+
+var slugPtrn = regexp.MustCompile("[^A-Za-z0-9]+")
+
+func (n SlugFilterNode) Expand(c *Context) (string, error) {
+	v, err := n.Node.Expand(c)
+	if err != nil {
+		return "", err
+	}
+	v = strings.Trim(slugPtrn.ReplaceAllString(v, " "), " ")
+	return strings.Replace(v, " ", n.Separator, -1), nil
+}
+
+func (n SlugFilterNode) Vars() []string {
+	return n.Node.Vars()
+}
+
+func (n SlugFilterNode) IntVars() []string {
+	return n.Node.IntVars()
+}
+
+type TruncFilterNode struct {
+	Node  TemplateNode
+	Width int
+}
+
+func (n TruncFilterNode) Expand(c *Context) (string, error) {
+	v, err := n.Node.Expand(c)
+	if err != nil {
+		return "", err
+	}
+	rs := []rune(v)
+	if len(rs) <= n.Width {
+		return v, nil
+	}
+	return string(rs[0:n.Width]), nil
+}
+
+func (n TruncFilterNode) Vars() []string {
+	return n.Node.Vars()
+}
+
+func (n TruncFilterNode) IntVars() []string {
+	return n.Node.IntVars()
+}
+
+type ReplaceFilterNode struct {
+	Node TemplateNode
+	Old  string
+	New  string
+}
+
+func (n ReplaceFilterNode) Expand(c *Context) (string, error) {
+	v, err := n.Node.Expand(c)
+	if err != nil {
+		return "", err
+	}
+	return strings.Replace(v, n.Old, n.New, -1), nil
+}
+
+func (n ReplaceFilterNode) Vars() []string {
+	return n.Node.Vars()
+}
+
+func (n ReplaceFilterNode) IntVars() []string {
+	return n.Node.IntVars()
+}
+
+// DefaultFilterNode substitutes its value when the expansion names an
+// unknown parameter or produces an empty string.  Other failures, such
+// as a repository that cannot be read, are passed through.
+type DefaultFilterNode struct {
+	Node  TemplateNode
+	Value string
+}
+
+func (n DefaultFilterNode) Expand(c *Context) (string, error) {
+	v, err := n.Node.Expand(c)
+	if err != nil && !IsMissingParameter(err) {
+		return "", err
+	}
+	if err != nil || v == "" {
+		return n.Value, nil
+	}
+	return v, nil
+}
+
+func (n DefaultFilterNode) Vars() []string {
+	return n.Node.Vars()
+}
+
+func (n DefaultFilterNode) IntVars() []string {
+	return n.Node.IntVars()
+}
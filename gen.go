@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GenField is a resolved data file field along with the type a code
+// generator should give it.
+type GenField struct {
+	Name  string
+	Type  string
+	Value string
+}
+
+// GenOptions carries the settings a generator may use.  FileName is the
+// base name of the output file, or empty when writing to stdout.
+type GenOptions struct {
+	Package  string
+	Prefix   string
+	FileName string
+}
+
+// GenFile is one file written by a generator.  Companion files, such as
+// a TypeScript declaration file, are named by replacing the output file's
+// extension with Ext.  The primary file has an empty Ext.
+type GenFile struct {
+	Ext     string
+	Content []byte
+}
+
+// Generator writes the data file fields as source code for one language.
+// Options lists the GenOptions settings it accepts from the command line.
+type Generator struct {
+	Name     string
+	Usage    string
+	Options  []string
+	Generate func(fields []GenField, opts GenOptions) ([]GenFile, error)
+}
+
+var Generators = []Generator{
+	{
+		Name:     "go",
+		Usage:    "Generate a Go source file with version constants.",
+		Options:  []string{"package"},
+		Generate: GenerateGo,
+	},
+	{
+		Name:     "c",
+		Usage:    "Generate a C header with version macros.",
+		Options:  []string{"prefix"},
+		Generate: GenerateC,
+	},
+	{
+		Name:     "python",
+		Usage:    "Generate a Python module with version variables.",
+		Generate: GeneratePython,
+	},
+	{
+		Name:     "js",
+		Usage:    "Generate an ES module and its TypeScript declarations.",
+		Generate: GenerateJs,
+	},
+	{
+		Name:     "rust",
+		Usage:    "Generate a Rust source file with version constants.",
+		Generate: GenerateRust,
+	},
+}
+
+// GenFields resolves the data file fields and assigns each one a type.
+// Integers and booleans keep their types only when the value actually
+// parses as such, since options from the command line can override them.
+func GenFields(ctx *Context) ([]GenField, error) {
+	data, err := DataFileData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fields := []GenField{}
+	for _, name := range sortedDataKeys(data) {
+		value := data[name]
+		t := TYPE_STRING
+		switch ctx.Config.ParameterType(name, *ctx.BranchConfig) {
+		case TYPE_INT:
+			if _, err := strconv.Atoi(value); err == nil {
+				t = TYPE_INT
+			}
+		case TYPE_BOOL:
+			if value == "true" || value == "false" {
+				t = TYPE_BOOL
+			}
+		}
+		fields = append(fields, GenField{Name: name, Type: t, Value: value})
+	}
+	return fields, nil
+}
+
+// intLiteral writes an integer field in canonical form, so that values
+// such as 08 are neither rejected nor read as octal by the target
+// language.
+func intLiteral(f GenField) string {
+	n, err := strconv.Atoi(f.Value)
+	if err != nil {
+		return f.Value
+	}
+	return strconv.Itoa(n)
+}
+
+// splitFieldName breaks a field name such as commit-hash-short into its
+// words.
+func splitFieldName(name string) []string {
+	return strings.FieldsFunc(name, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// identifiers names each field with nameFunc, refusing fields whose
+// names collide.
+func identifiers(fields []GenField, nameFunc func(string) string) ([]string, error) {
+	ids := []string{}
+	seen := map[string]string{}
+	for _, f := range fields {
+		id := nameFunc(f.Name)
+		if other, ok := seen[id]; ok {
+			return nil, fmt.Errorf("fields '%s' and '%s' both become identifier %s", other, f.Name, id)
+		}
+		seen[id] = f.Name
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+const generatedComment = "Code generated by vers. DO NOT EDIT."
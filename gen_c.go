@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// CIdentifier turns a field name such as commit-counter into the macro
+// name COMMIT_COUNTER.
+func CIdentifier(name string) string {
+	return MakeVariableName(name)
+}
+
+// QuoteC produces a C string literal.  Anything outside printable ASCII is
+// written as a three digit octal escape because hex escapes would swallow
+// following hex digits.  Question marks are escaped so that no trigraphs
+// can form.
+func QuoteC(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, c := range []byte(s) {
+		switch c {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '?':
+			b.WriteString(`\?`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if c < 0x20 || c > 0x7e {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// GenerateC writes a header of #define macros.  The include guard comes
+// from the output file's name when there is one, and booleans become 1 or
+// 0 so that they work in preprocessor conditionals.
+func GenerateC(fields []GenField, opts GenOptions) ([]GenFile, error) {
+	ids, err := identifiers(fields, func(name string) string {
+		return opts.Prefix + CIdentifier(name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	guard := opts.Prefix + "VERSION_H"
+	if opts.FileName != "" {
+		guard = MakeVariableName(opts.FileName)
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "/* %s */\n\n", generatedComment)
+	fmt.Fprintf(&b, "#ifndef %s\n#define %s\n\n", guard, guard)
+	for i, f := range fields {
+		switch f.Type {
+		case TYPE_INT:
+			v := intLiteral(f)
+			if strings.HasPrefix(v, "-") {
+				// Keeps expressions like X-1 from reading as X--1.
+				v = "(" + v + ")"
+			}
+			fmt.Fprintf(&b, "#define %s %s\n", ids[i], v)
+		case TYPE_BOOL:
+			v := "0"
+			if f.Value == "true" {
+				v = "1"
+			}
+			fmt.Fprintf(&b, "#define %s %s\n", ids[i], v)
+		default:
+			fmt.Fprintf(&b, "#define %s %s\n", ids[i], QuoteC(f.Value))
+		}
+	}
+	fmt.Fprintf(&b, "\n#endif /* %s */\n", guard)
+	return []GenFile{{Content: b.Bytes()}}, nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// GoIdentifier turns a field name such as commit-hash-short into the
+// exported identifier CommitHashShort.
+func GoIdentifier(name string) string {
+	var b strings.Builder
+	for _, w := range splitFieldName(name) {
+		rs := []rune(w)
+		b.WriteRune(unicode.ToUpper(rs[0]))
+		b.WriteString(string(rs[1:]))
+	}
+	id := b.String()
+	if id == "" || !unicode.IsLetter([]rune(id)[0]) {
+		id = "V" + id
+	}
+	return id
+}
+
+func GenerateGo(fields []GenField, opts GenOptions) ([]GenFile, error) {
+	pkg := opts.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+	ids, err := identifiers(fields, GoIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s\n\npackage %s\n\nconst (\n", generatedComment, pkg)
+	for i, f := range fields {
+		switch f.Type {
+		case TYPE_INT:
+			fmt.Fprintf(&b, "%s int = %s\n", ids[i], intLiteral(f))
+		case TYPE_BOOL:
+			fmt.Fprintf(&b, "%s bool = %s\n", ids[i], f.Value)
+		default:
+			fmt.Fprintf(&b, "%s string = %s\n", ids[i], strconv.Quote(f.Value))
+		}
+	}
+	b.WriteString(")\n")
+	out, err := format.Source(b.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("could not format generated Go source: %s", err)
+	}
+	return []GenFile{{Content: out}}, nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+var jsReservedWords = map[string]bool{
+	"await": true, "break": true, "case": true, "catch": true, "class": true,
+	"const": true, "continue": true, "debugger": true, "default": true,
+	"delete": true, "do": true, "else": true, "enum": true, "export": true,
+	"extends": true, "false": true, "finally": true, "for": true,
+	"function": true, "if": true, "implements": true, "import": true,
+	"in": true, "instanceof": true, "interface": true, "let": true,
+	"new": true, "null": true, "package": true, "private": true,
+	"protected": true, "public": true, "return": true, "static": true,
+	"super": true, "switch": true, "this": true, "throw": true, "true": true,
+	"try": true, "typeof": true, "var": true, "void": true, "while": true,
+	"with": true, "yield": true,
+}
+
+// JsIdentifier turns a field name such as commit-hash-short into the
+// camel case name commitHashShort.  Reserved words get a trailing
+// underscore.
+func JsIdentifier(name string) string {
+	var b strings.Builder
+	for i, w := range splitFieldName(name) {
+		rs := []rune(w)
+		if i == 0 {
+			b.WriteRune(unicode.ToLower(rs[0]))
+		} else {
+			b.WriteRune(unicode.ToUpper(rs[0]))
+		}
+		b.WriteString(string(rs[1:]))
+	}
+	id := b.String()
+	if id == "" || unicode.IsDigit([]rune(id)[0]) {
+		id = "_" + id
+	}
+	if jsReservedWords[id] {
+		id += "_"
+	}
+	return id
+}
+
+// QuoteJs produces a JavaScript string literal.  JSON strings are valid
+// JavaScript, and the encoder also escapes U+2028 and U+2029, which older
+// engines reject inside literals.
+func QuoteJs(s string) string {
+	out, err := json.Marshal(s)
+	if err != nil {
+		panic("strings always marshal")
+	}
+	return string(out)
+}
+
+// GenerateJs writes an ES module exporting a constant per field, along
+// with a matching TypeScript declaration file.
+func GenerateJs(fields []GenField, opts GenOptions) ([]GenFile, error) {
+	ids, err := identifiers(fields, JsIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	var js bytes.Buffer
+	var dts bytes.Buffer
+	fmt.Fprintf(&js, "// %s\n\n", generatedComment)
+	fmt.Fprintf(&dts, "// %s\n\n", generatedComment)
+	for i, f := range fields {
+		switch f.Type {
+		case TYPE_INT:
+			fmt.Fprintf(&js, "export const %s = %s;\n", ids[i], intLiteral(f))
+			fmt.Fprintf(&dts, "export declare const %s: number;\n", ids[i])
+		case TYPE_BOOL:
+			fmt.Fprintf(&js, "export const %s = %s;\n", ids[i], f.Value)
+			fmt.Fprintf(&dts, "export declare const %s: boolean;\n", ids[i])
+		default:
+			fmt.Fprintf(&js, "export const %s = %s;\n", ids[i], QuoteJs(f.Value))
+			fmt.Fprintf(&dts, "export declare const %s: string;\n", ids[i])
+		}
+	}
+	return []GenFile{{Content: js.Bytes()}, {Ext: JsDeclarationExt(opts.FileName), Content: dts.Bytes()}}, nil
+}
+
+// JsDeclarationExt picks the declaration file extension TypeScript looks
+// for beside a module, which follows the module's own extension.
+func JsDeclarationExt(fileName string) string {
+	switch filepath.Ext(fileName) {
+	case ".mjs":
+		return ".d.mts"
+	case ".cjs":
+		return ".d.cts"
+	}
+	return ".d.ts"
+}
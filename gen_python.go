@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+var pythonKeywords = map[string]bool{
+	"and": true, "as": true, "assert": true, "async": true, "await": true,
+	"break": true, "class": true, "continue": true, "def": true, "del": true,
+	"elif": true, "else": true, "except": true, "finally": true, "for": true,
+	"from": true, "global": true, "if": true, "import": true, "in": true,
+	"is": true, "lambda": true, "nonlocal": true, "not": true, "or": true,
+	"pass": true, "raise": true, "return": true, "try": true, "while": true,
+	"with": true, "yield": true,
+}
+
+// PythonIdentifier turns a field name such as commit-counter into the
+// variable name commit_counter.  Keywords get a trailing underscore.
+// Identifiers are lower case, so the capitalized keywords cannot occur.
+func PythonIdentifier(name string) string {
+	id := strings.ToLower(MakeVariableName(name))
+	if pythonKeywords[id] {
+		id += "_"
+	}
+	return id
+}
+
+// QuotePython produces a Python 3 string literal.  Source files are UTF-8,
+// so only quotes, backslashes, and control characters need escapes.
+func QuotePython(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f || r == 0x2028 || r == 0x2029 {
+				fmt.Fprintf(&b, `\u%04x`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// GeneratePython writes module level variables, plus the conventional
+// __version__ when the fields include the version.
+func GeneratePython(fields []GenField, opts GenOptions) ([]GenFile, error) {
+	ids, err := identifiers(fields, PythonIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# %s\n\n", generatedComment)
+	for i, f := range fields {
+		switch f.Type {
+		case TYPE_INT:
+			fmt.Fprintf(&b, "%s = %s\n", ids[i], intLiteral(f))
+		case TYPE_BOOL:
+			v := "False"
+			if f.Value == "true" {
+				v = "True"
+			}
+			fmt.Fprintf(&b, "%s = %s\n", ids[i], v)
+		default:
+			fmt.Fprintf(&b, "%s = %s\n", ids[i], QuotePython(f.Value))
+		}
+		if f.Name == "version" {
+			fmt.Fprintf(&b, "__version__ = %s\n", ids[i])
+		}
+	}
+	return []GenFile{{Content: b.Bytes()}}, nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// RustIdentifier turns a field name such as commit-counter into the
+// constant name COMMIT_COUNTER.
+func RustIdentifier(name string) string {
+	return MakeVariableName(name)
+}
+
+// QuoteRust produces a Rust string literal.  Source files are UTF-8, so
+// only quotes, backslashes, and control characters need escapes.
+func QuoteRust(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"':
+			b.WriteString(`\"`)
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		default:
+			if r < 0x20 || r == 0x7f {
+				fmt.Fprintf(&b, `\u{%x}`, r)
+			} else {
+				b.WriteRune(r)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func GenerateRust(fields []GenField, opts GenOptions) ([]GenFile, error) {
+	ids, err := identifiers(fields, RustIdentifier)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// %s\n\n", generatedComment)
+	for i, f := range fields {
+		switch f.Type {
+		case TYPE_INT:
+			fmt.Fprintf(&b, "pub const %s: i64 = %s;\n", ids[i], intLiteral(f))
+		case TYPE_BOOL:
+			fmt.Fprintf(&b, "pub const %s: bool = %s;\n", ids[i], f.Value)
+		default:
+			fmt.Fprintf(&b, "pub const %s: &str = %s;\n", ids[i], QuoteRust(f.Value))
+		}
+	}
+	return []GenFile{{Content: b.Bytes()}}, nil
+}
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var goIdentifierTests = []struct {
+	Name string
+	Id   string
+}{
+	{"version", "Version"},
+	{"commit-hash-short", "CommitHashShort"},
+	{"build_id", "BuildId"},
+	{"2fa", "V2fa"},
+}
+
+func TestGoIdentifier(t *testing.T) {
+	for _, tc := range goIdentifierTests {
+		if id := GoIdentifier(tc.Name); id != tc.Id {
+			t.Errorf("%s: wanted %s but got %s", tc.Name, tc.Id, id)
+		}
+	}
+}
+
+var genTestFields = []GenField{
+	{Name: "branch", Type: TYPE_STRING, Value: "feature/\"quoted\""},
+	{Name: "commit-counter", Type: TYPE_INT, Value: "32"},
+	{Name: "dirty", Type: TYPE_BOOL, Value: "false"},
+	{Name: "version", Type: TYPE_STRING, Value: "1.0.0"},
+}
+
+func TestGenerateGo(t *testing.T) {
+	out, err := GenerateGo(genTestFields, GenOptions{Package: "buildinfo"})
+	failWhenErr(t, err)
+	expected := `// Code generated by vers. DO NOT EDIT.
+
+package buildinfo
+
+const (
+	Branch        string = "feature/\"quoted\""
+	CommitCounter int    = 32
+	Dirty         bool   = false
+	Version       string = "1.0.0"
+)
+`
+	if string(out[0].Content) != expected {
+		t.Errorf("wanted:\n%s\nbut got:\n%s", expected, out[0].Content)
+	}
+}
+
+func TestGenerateGoRejectsCollidingNames(t *testing.T) {
+	_, err := GenerateGo([]GenField{
+		{Name: "build-id", Type: TYPE_STRING, Value: "a"},
+		{Name: "build_id", Type: TYPE_STRING, Value: "b"},
+	}, GenOptions{})
+	failWhen(t, err == nil)
+}
+
+func TestGenerateGoRejectsBadPackage(t *testing.T) {
+	_, err := GenerateGo(genTestFields, GenOptions{Package: "not a package"})
+	failWhen(t, err == nil)
+}
+
+func TestGenFieldsKeepTypesOnlyWhenValuesMatch(t *testing.T) {
+	config := Config{
+		Data: map[string]interface{}{
+			"major": 1,
+			"minor": 2,
+			"name":  "vers",
+		},
+		Branches: []BranchConfig{{
+			BranchPattern:   ".*",
+			VersionTemplate: "{major}.{minor}",
+		}},
+		DataFileFields: []string{"major", "minor", "name"},
+	}
+	ctx := NewContext("version.json", &config, []Option{{Name: "minor", Value: "rc1"}})
+	ctx.BranchConfig = &config.Branches[0]
+	fields, err := GenFields(&ctx)
+	failWhenErr(t, err)
+	expected := []GenField{
+		{Name: "major", Type: TYPE_INT, Value: "1"},
+		{Name: "minor", Type: TYPE_STRING, Value: "rc1"},
+		{Name: "name", Type: TYPE_STRING, Value: "vers"},
+	}
+	if len(fields) != len(expected) {
+		t.Fatalf("wanted %v but got %v", expected, fields)
+	}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("wanted %v but got %v", expected[i], fields[i])
+		}
+	}
+}
+
+func TestGenerateC(t *testing.T) {
+	out, err := GenerateC(genTestFields, GenOptions{Prefix: "LIB_", FileName: "lib-version.h"})
+	failWhenErr(t, err)
+	expected := `/* Code generated by vers. DO NOT EDIT. */
+
+#ifndef LIB_VERSION_H
+#define LIB_VERSION_H
+
+#define LIB_BRANCH "feature/\"quoted\""
+#define LIB_COMMIT_COUNTER 32
+#define LIB_DIRTY 0
+#define LIB_VERSION "1.0.0"
+
+#endif /* LIB_VERSION_H */
+`
+	if string(out[0].Content) != expected {
+		t.Errorf("wanted:\n%s\nbut got:\n%s", expected, out[0].Content)
+	}
+}
+
+func TestGeneratePython(t *testing.T) {
+	out, err := GeneratePython(genTestFields, GenOptions{})
+	failWhenErr(t, err)
+	expected := `# Code generated by vers. DO NOT EDIT.
+
+branch = "feature/\"quoted\""
+commit_counter = 32
+dirty = False
+version = "1.0.0"
+__version__ = version
+`
+	if string(out[0].Content) != expected {
+		t.Errorf("wanted:\n%s\nbut got:\n%s", expected, out[0].Content)
+	}
+}
+
+func TestGenerateJs(t *testing.T) {
+	out, err := GenerateJs(genTestFields, GenOptions{})
+	failWhenErr(t, err)
+	failWhen(t, len(out) != 2)
+	js := `// Code generated by vers. DO NOT EDIT.
+
+export const branch = "feature/\"quoted\"";
+export const commitCounter = 32;
+export const dirty = false;
+export const version = "1.0.0";
+`
+	dts := `// Code generated by vers. DO NOT EDIT.
+
+export declare const branch: string;
+export declare const commitCounter: number;
+export declare const dirty: boolean;
+export declare const version: string;
+`
+	if string(out[0].Content) != js {
+		t.Errorf("wanted:\n%s\nbut got:\n%s", js, out[0].Content)
+	}
+	failWhen(t, out[1].Ext != ".d.ts")
+	if string(out[1].Content) != dts {
+		t.Errorf("wanted:\n%s\nbut got:\n%s", dts, out[1].Content)
+	}
+}
+
+func TestJsDeclarationExt(t *testing.T) {
+	var cases = []struct {
+		FileName string
+		Ext      string
+	}{
+		{"", ".d.ts"},
+		{"version.js", ".d.ts"},
+		{"version.mjs", ".d.mts"},
+		{"version.cjs", ".d.cts"},
+	}
+	for _, tc := range cases {
+		out, err := GenerateJs(genTestFields, GenOptions{FileName: tc.FileName})
+		failWhenErr(t, err)
+		if out[1].Ext != tc.Ext {
+			t.Errorf("%s: wanted declarations in %s but got %s", tc.FileName, tc.Ext, out[1].Ext)
+		}
+	}
+}
+
+func TestGenerateRust(t *testing.T) {
+	out, err := GenerateRust(genTestFields, GenOptions{})
+	failWhenErr(t, err)
+	expected := `// Code generated by vers. DO NOT EDIT.
+
+pub const BRANCH: &str = "feature/\"quoted\"";
+pub const COMMIT_COUNTER: i64 = 32;
+pub const DIRTY: bool = false;
+pub const VERSION: &str = "1.0.0";
+`
+	if string(out[0].Content) != expected {
+		t.Errorf("wanted:\n%s\nbut got:\n%s", expected, out[0].Content)
+	}
+}
+
+var identifierTests = []struct {
+	NameFunc func(string) string
+	Name     string
+	Id       string
+}{
+	{CIdentifier, "commit-hash-short", "COMMIT_HASH_SHORT"},
+	{PythonIdentifier, "commit-hash-short", "commit_hash_short"},
+	{PythonIdentifier, "class", "class_"},
+	{JsIdentifier, "commit-hash-short", "commitHashShort"},
+	{JsIdentifier, "default", "default_"},
+	{JsIdentifier, "2fa", "_2fa"},
+	{RustIdentifier, "commit-hash-short", "COMMIT_HASH_SHORT"},
+}
+
+func TestIdentifiers(t *testing.T) {
+	for _, tc := range identifierTests {
+		if id := tc.NameFunc(tc.Name); id != tc.Id {
+			t.Errorf("%s: wanted %s but got %s", tc.Name, tc.Id, id)
+		}
+	}
+}
+
+// awkwardValue exercises the escaping in every generator.
+const awkwardValue = "q\"b\\s'n\nt\tc\x01?? é ☃   end"
+
+// checkGeneratedValue compiles or runs generated code with the language's
+// own tools and checks that the value survives the round trip.
+func checkGeneratedValue(t *testing.T, g func([]GenField, GenOptions) ([]GenFile, error), file string, name string, args ...string) {
+	skipWithoutCommand(t, name)
+	dir, err := ioutil.TempDir("", "vers-gen")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	out, err := g([]GenField{{Name: "value", Type: TYPE_STRING, Value: awkwardValue}}, GenOptions{})
+	failWhenErr(t, err)
+	failWhenErr(t, ioutil.WriteFile(filepath.Join(dir, file), out[0].Content, 0664))
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	got, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("%s failed: %s", name, err)
+	}
+	if string(got) != awkwardValue {
+		t.Errorf("wanted %q but got %q", awkwardValue, string(got))
+	}
+}
+
+func TestGeneratedPythonEscaping(t *testing.T) {
+	checkGeneratedValue(t, GeneratePython, "_version.py", "python3", "-c",
+		"import sys, _version; sys.stdout.buffer.write(_version.value.encode())")
+}
+
+func TestGeneratedJsEscaping(t *testing.T) {
+	checkGeneratedValue(t, GenerateJs, "version.mjs", "node", "-e",
+		"import('./version.mjs').then(v => process.stdout.write(v.value))")
+}
+
+func TestGeneratedCEscaping(t *testing.T) {
+	checkGeneratedValue(t, GenerateC, "version.h", "sh", "-c",
+		"printf '#include <stdio.h>\\n#include \"version.h\"\\nint main(void) { fputs(VALUE, stdout); return 0; }\\n' > main.c && cc -o main main.c && ./main")
+}
+
+func TestGeneratedRustEscaping(t *testing.T) {
+	skipWithoutCommand(t, "rustc")
+	checkGeneratedValue(t, GenerateRust, "version.rs", "sh", "-c",
+		"printf 'include!(\"version.rs\");\\nfn main() { print!(\"{}\", VALUE); }\\n' > main.rs && rustc -o main main.rs >&2 && ./main")
+}
+
+func TestGeneratedIntegersDropLeadingZeros(t *testing.T) {
+	fields := []GenField{
+		{Name: "minor", Type: TYPE_INT, Value: "08"},
+		{Name: "offset", Type: TYPE_INT, Value: "-010"},
+	}
+	var cases = []struct {
+		Generate func([]GenField, GenOptions) ([]GenFile, error)
+		Expected []string
+	}{
+		{GenerateGo, []string{"Minor  int = 8\n", "Offset int = -10\n"}},
+		{GenerateC, []string{"#define MINOR 8\n", "#define OFFSET (-10)\n"}},
+		{GeneratePython, []string{"minor = 8\n", "offset = -10\n"}},
+		{GenerateJs, []string{"export const minor = 8;\n", "export const offset = -10;\n"}},
+		{GenerateRust, []string{"pub const MINOR: i64 = 8;\n", "pub const OFFSET: i64 = -10;\n"}},
+	}
+	for _, tc := range cases {
+		out, err := tc.Generate(fields, GenOptions{Package: "buildinfo"})
+		failWhenErr(t, err)
+		for _, e := range tc.Expected {
+			if !strings.Contains(string(out[0].Content), e) {
+				t.Errorf("wanted %q in:\n%s", e, out[0].Content)
+			}
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	LabelVersion  = "org.opencontainers.image.version"
+	LabelRevision = "org.opencontainers.image.revision"
+	LabelCreated  = "org.opencontainers.image.created"
+)
+
+// ImageMetadata holds the OCI annotations and tags for a container image.
+type ImageMetadata struct {
+	Labels map[string]string `json:"labels"`
+	Tags   []string          `json:"tags"`
+}
+
+// NewImageMetadata derives labels and tags from the version.  A release
+// version such as 1.2.3 is also tagged 1.2 and 1, and builds from the
+// latest branch are tagged latest.  When image is not empty it prefixes
+// every tag.
+func NewImageMetadata(ctx *Context, image string) (ImageMetadata, error) {
+	version, err := LookupParameter("version", ctx)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	created, err := LookupParameter("build-time", ctx)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	m := ImageMetadata{
+		Labels: map[string]string{
+			LabelVersion: version,
+			LabelCreated: created,
+		},
+	}
+	// Not every RCS has commit hashes, so the revision is optional.
+	revision, err := LookupParameter("commit-hash", ctx)
+	if err == nil {
+		m.Labels[LabelRevision] = revision
+	}
+
+	tags, err := ImageTags(version)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	latest, err := isLatestBranch(ctx)
+	if err != nil {
+		return ImageMetadata{}, err
+	}
+	// Like the shorter tags, latest must never point at a prerelease.
+	if latest && IsReleaseVersion(version) {
+		tags = append(tags, "latest")
+	}
+	for _, t := range tags {
+		if image != "" {
+			t = image + ":" + t
+		}
+		m.Tags = append(m.Tags, t)
+	}
+	return m, nil
+}
+
+var releaseVersionPtrn = regexp.MustCompile(`^(v?)([0-9]+)\.([0-9]+)\.([0-9]+)$`)
+
+func IsReleaseVersion(version string) bool {
+	return releaseVersionPtrn.MatchString(version)
+}
+
+// ImageTags lists the tags for a version.  Only release versions get the
+// shorter major.minor and major tags, since a prerelease should never
+// replace the release that those tags point at.  A leading v is kept on
+// all of them.
+func ImageTags(version string) ([]string, error) {
+	tag, err := ImageTag(version)
+	if err != nil {
+		return nil, err
+	}
+	tags := []string{tag}
+	m := releaseVersionPtrn.FindStringSubmatch(version)
+	if m != nil {
+		tags = append(tags, m[1]+m[2]+"."+m[3], m[1]+m[2])
+	}
+	return tags, nil
+}
+
+var invalidImageTagPtrn = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// ImageTag makes a version usable as an image tag, which allows at most
+// 128 letters, digits, underscores, periods, and dashes, and cannot start
+// with a period or dash.  Other characters, such as the + of semver build
+// metadata, become dashes.
+func ImageTag(version string) (string, error) {
+	tag := invalidImageTagPtrn.ReplaceAllString(version, "-")
+	tag = strings.TrimLeft(tag, ".-")
+	if len(tag) > 128 {
+		tag = tag[:128]
+	}
+	if tag == "" {
+		return "", fmt.Errorf("cannot make an image tag from version '%s'", version)
+	}
+	return tag, nil
+}
+
+// isLatestBranch decides whether the build should be tagged latest.
+// Branch configs can say so with their latest setting, and when none of
+// them do, builds from main or master count as latest.
+func isLatestBranch(ctx *Context) (bool, error) {
+	for _, bc := range ctx.Config.Branches {
+		if bc.Latest {
+			return ctx.BranchConfig.Latest, nil
+		}
+	}
+	branch, err := LookupParameter("branch", ctx)
+	if err != nil {
+		return false, err
+	}
+	return branch == "main" || branch == "master", nil
+}
+
+// Args renders the metadata as docker build arguments, quoted for the
+// shell where necessary.
+func (m ImageMetadata) Args() []string {
+	args := []string{}
+	for _, k := range sortedDataKeys(m.Labels) {
+		args = append(args, "--label", ShellQuote(k+"="+m.Labels[k]))
+	}
+	for _, t := range m.Tags {
+		args = append(args, "--tag", ShellQuote(t))
+	}
+	return args
+}
+
+func (m ImageMetadata) Json() ([]byte, error) {
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+var shellSafePtrn = regexp.MustCompile(`^[A-Za-z0-9_./:=+@%,-]+$`)
+
+// ShellQuote single quotes a string unless the shell would read it
+// unchanged anyway.
+func ShellQuote(s string) string {
+	if shellSafePtrn.MatchString(s) {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+var imageTagsTests = []struct {
+	Version string
+	Tags    []string
+}{
+	{"1.2.3", []string{"1.2.3", "1.2", "1"}},
+	{"v1.2.3", []string{"v1.2.3", "v1.2", "v1"}},
+	{"1.2.3-rc.1", []string{"1.2.3-rc.1"}},
+	{"1.2.3+build.5", []string{"1.2.3-build.5"}},
+	{"feature/login.42", []string{"feature-login.42"}},
+	{"-1.0", []string{"1.0"}},
+}
+
+func TestImageTags(t *testing.T) {
+	for _, tc := range imageTagsTests {
+		tags, err := ImageTags(tc.Version)
+		failWhenErr(t, err)
+		if !reflect.DeepEqual(tags, tc.Tags) {
+			t.Errorf("%s: wanted %v but got %v", tc.Version, tc.Tags, tags)
+		}
+	}
+}
+
+func TestImageTagRejectsEmptyTag(t *testing.T) {
+	_, err := ImageTag("...")
+	failWhen(t, err == nil)
+}
+
+func imageContext(branches []BranchConfig, branch string) *Context {
+	config := Config{Branches: branches}
+	ctx := NewContext("version.json", &config, []Option{
+		{Name: "branch", Value: branch},
+		{Name: "commit-hash", Value: "abc123"},
+	})
+	bc, _, _ := config.getBranchConfig(branch)
+	ctx.BranchConfig = bc
+	ctx.State["version"] = "1.2.3"
+	return &ctx
+}
+
+func TestNewImageMetadata(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+	ctx := imageContext([]BranchConfig{{BranchPattern: ".*", VersionTemplate: "1.2.3"}}, "main")
+	m, err := NewImageMetadata(ctx, "example/app")
+	failWhenErr(t, err)
+	labels := map[string]string{
+		LabelVersion:  "1.2.3",
+		LabelRevision: "abc123",
+		LabelCreated:  "2017-07-14T02:40:00Z",
+	}
+	if !reflect.DeepEqual(m.Labels, labels) {
+		t.Errorf("wanted %v but got %v", labels, m.Labels)
+	}
+	tags := []string{"example/app:1.2.3", "example/app:1.2", "example/app:1", "example/app:latest"}
+	if !reflect.DeepEqual(m.Tags, tags) {
+		t.Errorf("wanted %v but got %v", tags, m.Tags)
+	}
+	args := []string{
+		"--label", "org.opencontainers.image.created=2017-07-14T02:40:00Z",
+		"--label", "org.opencontainers.image.revision=abc123",
+		"--label", "org.opencontainers.image.version=1.2.3",
+		"--tag", "example/app:1.2.3",
+		"--tag", "example/app:1.2",
+		"--tag", "example/app:1",
+		"--tag", "example/app:latest",
+	}
+	if !reflect.DeepEqual(m.Args(), args) {
+		t.Errorf("wanted %v but got %v", args, m.Args())
+	}
+}
+
+func TestPrereleasesAreNotLatest(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "1500000000")
+	ctx := imageContext([]BranchConfig{{BranchPattern: ".*", VersionTemplate: "1.2.3-rc.1"}}, "main")
+	ctx.State["version"] = "1.2.3-rc.1"
+	m, err := NewImageMetadata(ctx, "example/app")
+	failWhenErr(t, err)
+	tags := []string{"example/app:1.2.3-rc.1"}
+	if !reflect.DeepEqual(m.Tags, tags) {
+		t.Errorf("wanted %v but got %v", tags, m.Tags)
+	}
+}
+
+var latestTests = []struct {
+	Branches []BranchConfig
+	Branch   string
+	Latest   bool
+}{
+	{[]BranchConfig{{BranchPattern: ".*", VersionTemplate: "1"}}, "master", true},
+	{[]BranchConfig{{BranchPattern: ".*", VersionTemplate: "1"}}, "feature", false},
+	{[]BranchConfig{
+		{BranchPattern: "release", VersionTemplate: "1", Latest: true},
+		{BranchPattern: ".*", VersionTemplate: "1"},
+	}, "release", true},
+	{[]BranchConfig{
+		{BranchPattern: "release", VersionTemplate: "1", Latest: true},
+		{BranchPattern: ".*", VersionTemplate: "1"},
+	}, "main", false},
+}
+
+func TestIsLatestBranch(t *testing.T) {
+	for _, tc := range latestTests {
+		latest, err := isLatestBranch(imageContext(tc.Branches, tc.Branch))
+		failWhenErr(t, err)
+		if latest != tc.Latest {
+			t.Errorf("%s: wanted latest=%v", tc.Branch, tc.Latest)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	failWhen(t, ShellQuote("a=b") != "a=b")
+	failWhen(t, ShellQuote("a b") != "'a b'")
+	failWhen(t, ShellQuote("it's") != `'it'\''s'`)
+	failWhen(t, ShellQuote("") != "''")
+}
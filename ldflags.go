@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ldflagSymbolPtrn matches the package qualified variable names accepted
+// by the linker's -X flag, such as main.version or example.com/pkg.Commit.
+var ldflagSymbolPtrn = regexp.MustCompile(`^[^\s=]+\.[^\s=./]+$`)
+
+func checkLdFlags(ldflags map[string]string) error {
+	for sym, param := range ldflags {
+		if !ldflagSymbolPtrn.MatchString(sym) {
+			return fmt.Errorf("ldflags symbol '%s' must be a package qualified name such as main.version", sym)
+		}
+		if param == "" {
+			return fmt.Errorf("ldflags symbol '%s' requires a parameter", sym)
+		}
+	}
+	return nil
+}
+
+// LdFlags produces linker flags setting each symbol in the version file's
+// ldflags section to its parameter's value.  Symbols are sorted so that
+// the output is stable.
+func LdFlags(ctx *Context) (string, error) {
+	syms := []string{}
+	for sym := range ctx.Config.LdFlags {
+		syms = append(syms, sym)
+	}
+	sort.Strings(syms)
+	flags := []string{}
+	for _, sym := range syms {
+		value, err := LookupParameter(ctx.Config.LdFlags[sym], ctx)
+		if err != nil {
+			return "", err
+		}
+		arg, err := QuoteLdFlag(sym + "=" + value)
+		if err != nil {
+			return "", err
+		}
+		flags = append(flags, "-X", arg)
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// QuoteLdFlag quotes an argument the way the go command splits -ldflags.
+// Arguments are separated by whitespace, and an argument starting with a
+// single or double quote runs to the next matching quote.  There are no
+// escapes, so an argument containing both kinds of quote cannot be
+// represented.
+func QuoteLdFlag(arg string) (string, error) {
+	if arg != "" && !strings.ContainsAny(arg, " \t\n\r") && arg[0] != '\'' && arg[0] != '"' {
+		return arg, nil
+	}
+	if !strings.Contains(arg, "'") {
+		return "'" + arg + "'", nil
+	}
+	if !strings.Contains(arg, "\"") {
+		return "\"" + arg + "\"", nil
+	}
+	return "", fmt.Errorf("cannot quote '%s' for ldflags because it contains both kinds of quote", arg)
+}
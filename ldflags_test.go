@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+var quoteLdFlagTests = []struct {
+	Arg    string
+	Quoted string
+}{
+	{"main.version=1.0.0", "main.version=1.0.0"},
+	{"main.version=1.0 beta", "'main.version=1.0 beta'"},
+	{"main.version=it's new", "\"main.version=it's new\""},
+	{"main.version=it's", "main.version=it's"},
+	{"main.v=\"x\"", "main.v=\"x\""},
+	{"main.v=a\tb", "'main.v=a\tb'"},
+}
+
+func TestQuoteLdFlag(t *testing.T) {
+	for _, tc := range quoteLdFlagTests {
+		q, err := QuoteLdFlag(tc.Arg)
+		failWhenErr(t, err)
+		if q != tc.Quoted {
+			t.Errorf("%q: wanted %q but got %q", tc.Arg, tc.Quoted, q)
+		}
+	}
+}
+
+func TestQuoteLdFlagRejectsBothQuotes(t *testing.T) {
+	_, err := QuoteLdFlag("main.v=it's \"x\"")
+	failWhen(t, err == nil)
+}
+
+var checkLdFlagsTests = []struct {
+	LdFlags map[string]string
+	Valid   bool
+}{
+	{map[string]string{"main.version": "version"}, true},
+	{map[string]string{"github.com/x/y/buildinfo.Commit": "commit-hash"}, true},
+	{map[string]string{"version": "version"}, false},
+	{map[string]string{"main.": "version"}, false},
+	{map[string]string{"main.a b": "version"}, false},
+	{map[string]string{"main.version": ""}, false},
+}
+
+func TestCheckLdFlags(t *testing.T) {
+	for _, tc := range checkLdFlagsTests {
+		err := checkLdFlags(tc.LdFlags)
+		if (err == nil) != tc.Valid {
+			t.Errorf("%v: expected valid=%v but got %v", tc.LdFlags, tc.Valid, err)
+		}
+	}
+}
+
+func TestLdFlags(t *testing.T) {
+	config := Config{
+		Data: map[string]interface{}{
+			"release": 3,
+		},
+		Branches: []BranchConfig{{
+			BranchPattern:   ".*",
+			VersionTemplate: "1.{release}",
+		}},
+		LdFlags: map[string]string{
+			"main.version": "version",
+			"main.note":    "note",
+			"main.release": "release",
+		},
+	}
+	ctx := NewContext("version.json", &config, []Option{{Name: "note", Value: "hello world"}})
+	ctx.BranchConfig = &config.Branches[0]
+	ctx.State["version"] = "1.3"
+	flags, err := LdFlags(&ctx)
+	failWhenErr(t, err)
+	expected := "-X 'main.note=hello world' -X main.release=3 -X main.version=1.3"
+	if flags != expected {
+		t.Errorf("wanted %q but got %q", expected, flags)
+	}
+}
@@ -1,13 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/urfave/cli"
 )
@@ -24,6 +23,10 @@ func main() {
 			Name:  "file, f",
 			Usage: "Version file",
 		},
+		cli.StringFlag{
+			Name:  "rcs",
+			Usage: "RCS backend (git, git-native, jj, svn, hg, or fossil)",
+		},
 	}
 
 	app.Commands = []cli.Command{
@@ -71,8 +74,126 @@ func main() {
 					Name:  "data-file, o",
 					Usage: "Data file",
 				},
+				exitCodeFlag,
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Data file format (" + strings.Join(DataFileFormats(), ", ") + ")",
+				},
+			},
+		},
+		{
+			Name:   "ldflags",
+			Action: actionLdFlags,
+			Usage:  "Print Go linker flags setting version variables.",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "option, X",
+					Usage: "Specified option",
+				},
+			},
+		},
+		{
+			Name:   "stamp",
+			Action: actionStamp,
+			Usage:  "Set the version in package manifests.",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "option, X",
+					Usage: "Specified option",
+				},
+				exitCodeFlag,
+			},
+		},
+		{
+			Name:   "image",
+			Action: actionImage,
+			Usage:  "Print container image labels and tags.",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "option, X",
+					Usage: "Specified option",
+				},
+				cli.StringFlag{
+					Name:  "image",
+					Usage: "Image name to prefix tags with",
+				},
+				cli.StringFlag{
+					Name:  "format",
+					Usage: "Output format (args or json)",
+					Value: "args",
+				},
+			},
+		},
+		{
+			Name:  "package",
+			Usage: "Print the version in a package manager's scheme.",
+			Subcommands: []cli.Command{
+				{
+					Name:   "deb",
+					Action: actionPackageDeb,
+					Usage:  "Print a Debian package version.",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "option, X",
+							Usage: "Specified option",
+						},
+						cli.StringFlag{
+							Name:  "epoch",
+							Usage: "Package epoch",
+						},
+						cli.StringFlag{
+							Name:  "revision",
+							Usage: "Debian revision",
+						},
+					},
+				},
+				{
+					Name:   "rpm",
+					Action: actionPackageRpm,
+					Usage:  "Print RPM Version and Release fields.",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "option, X",
+							Usage: "Specified option",
+						},
+						cli.StringFlag{
+							Name:  "epoch",
+							Usage: "Package epoch",
+						},
+						cli.StringFlag{
+							Name:  "release",
+							Usage: "RPM release",
+							Value: "1",
+						},
+					},
+				},
 			},
 		},
+		{
+			Name:   "render",
+			Action: actionRender,
+			Usage:  "Expand a template file.",
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "option, X",
+					Usage: "Specified option",
+				},
+				cli.StringFlag{
+					Name:  "template, t",
+					Usage: "Template file",
+				},
+				cli.StringFlag{
+					Name:  "output, o",
+					Usage: "Output file",
+				},
+				exitCodeFlag,
+			},
+		},
+		{
+			Name:        "gen",
+			Usage:       "Generate source code containing version information.",
+			Subcommands: genCommands(),
+		},
 		{
 			Name:   "bump-major",
 			Usage:  "Increment major version number in version file.",
@@ -174,7 +295,7 @@ func createInitFile(versionFile string, templateName string, rcsName string) err
 		return fmt.Errorf("unnknown template: %s", templateName)
 	}
 	if rcsName == "" {
-		rcs, err := GetRcs(filepath.Dir(versionFile))
+		rcs, err := GetRcs(filepath.Dir(versionFile), &Config{})
 		if err == nil {
 			rcsName = rcs.Name()
 		}
@@ -187,7 +308,7 @@ func createInitFile(versionFile string, templateName string, rcsName string) err
 
 func RcsDataFileFields(rcsName string) []string {
 	switch rcsName {
-	case "git":
+	case "git", "git-native", "hg", "fossil", "jj":
 		return []string{
 			"commit-hash",
 			"commit-hash-short",
@@ -208,142 +329,378 @@ func actionShow(c *cli.Context) error {
 		return errors.New("version file required")
 	}
 
-	config, err := readConfig(vf)
+	// Get options from command line
+	opts, err := getOptions(c)
 	if err != nil {
 		return err
 	}
-	if config.Branches == nil {
-		return fmt.Errorf("Could not parse branches")
+
+	ctx, err := NewBranchContext(vf, c.GlobalString("rcs"), opts)
+	if err != nil {
+		return err
 	}
 
-	// Get options from command line
-	opts, err := getOptions(c)
+	version, err := ExpandVersion(ctx)
 	if err != nil {
 		return err
 	}
+	fmt.Println(version)
+	return nil
+}
 
-	ctx := NewContext(vf, config, opts)
+func actionDataFile(c *cli.Context) error {
+	df := c.String("data-file")
 
-	// get branch from combination of supplied variables and lazy RCS
-	branch, err := LookupParameter("branch", &ctx)
+	ctx, err := newVersionContext(c)
 	if err != nil {
 		return err
 	}
 
-	// locate appropriate branch config
-	// if branch does not match, error
-	branchConfig, branchParams, err := config.getBranchConfig(branch)
+	data, err := DataFileData(ctx)
 	if err != nil {
 		return err
 	}
 
-	ctx.BranchParams = *branchParams
-	ctx.BranchConfig = branchConfig
+	format := c.String("format")
+	if format == "" {
+		format = ctx.Config.DataFileFormat
+	}
+	if format == "" {
+		format = DefaultDataFileFormat
+	}
 
-	format, err := ParseString(branchConfig.VersionTemplate)
+	if df == "" {
+		df = os.Getenv(DataFileTargets[format])
+	}
+	if df == "" {
+		out, err := FormatDataFile(format, data)
+		if err != nil {
+			return err
+		}
+		// JSON has no trailing newline of its own, but it has always
+		// ended with one on stdout.
+		fmt.Print(string(out))
+		if !strings.HasSuffix(string(out), "\n") {
+			fmt.Println()
+		}
+		return nil
+	}
+	changed, err := writeDataFile(df, format, data)
 	if err != nil {
 		return err
 	}
+	return changedExit(c, changed)
+}
 
-	// perform expansion
-	version, err := format.Expand(&ctx)
+func actionLdFlags(c *cli.Context) error {
+	ctx, err := newVersionContext(c)
 	if err != nil {
 		return err
 	}
-	fmt.Println(version)
+	if len(ctx.Config.LdFlags) == 0 {
+		return errors.New("version file has no ldflags section")
+	}
+	flags, err := LdFlags(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Println(flags)
 	return nil
 }
 
-func actionDataFile(c *cli.Context) error {
-	vf, err := GetVersionFile(c)
+func actionStamp(c *cli.Context) error {
+	ctx, err := newVersionContext(c)
 	if err != nil {
-		return errors.New("version file required")
+		return err
 	}
+	if len(ctx.Config.Stamp) == 0 {
+		return errors.New("version file has no stamp section")
+	}
+	changed, err := StampManifests(ctx)
+	if err != nil {
+		return err
+	}
+	return changedExit(c, changed)
+}
 
-	df := c.String("data-file")
-
-	config, err := readConfig(vf)
+func actionImage(c *cli.Context) error {
+	format := c.String("format")
+	if format != "args" && format != "json" {
+		return fmt.Errorf("unknown image format '%s'", format)
+	}
+	ctx, err := newVersionContext(c)
 	if err != nil {
 		return err
 	}
-	if config.Branches == nil {
-		return fmt.Errorf("Could not parse branches")
+	m, err := NewImageMetadata(ctx, c.String("image"))
+	if err != nil {
+		return err
+	}
+	if format == "json" {
+		out, err := m.Json()
+		if err != nil {
+			return err
+		}
+		fmt.Print(string(out))
+		return nil
 	}
+	fmt.Println(strings.Join(m.Args(), " "))
+	return nil
+}
 
-	// Get options from command line
-	opts, err := getOptions(c)
+func actionPackageDeb(c *cli.Context) error {
+	ctx, err := newVersionContext(c)
 	if err != nil {
 		return err
 	}
-
-	ctx := NewContext(vf, config, opts)
-
-	// get branch from combination of supplied variables and lazy RCS
-	branch, err := LookupParameter("branch", &ctx)
+	v, err := DebianVersion(ctx.State["version"], c.String("epoch"), c.String("revision"))
 	if err != nil {
 		return err
 	}
+	fmt.Println(v)
+	return nil
+}
 
-	// locate appropriate branch config
-	// if branch does not match, error
-	branchConfig, branchParams, err := config.getBranchConfig(branch)
+func actionPackageRpm(c *cli.Context) error {
+	ctx, err := newVersionContext(c)
 	if err != nil {
 		return err
 	}
-	ctx.BranchParams = *branchParams
-	ctx.BranchConfig = branchConfig
-
-	format, err := ParseString(branchConfig.VersionTemplate)
+	v, err := NewRpmVersion(ctx.State["version"], c.String("epoch"), c.String("release"))
 	if err != nil {
 		return err
 	}
+	fmt.Print(v.SpecFields())
+	return nil
+}
 
-	// perform expansion
-	version, err := format.Expand(&ctx)
+func actionRender(c *cli.Context) error {
+	tf := c.String("template")
+	if tf == "" {
+		return errors.New("template file required")
+	}
+	ctx, err := newVersionContext(c)
 	if err != nil {
 		return err
 	}
+	out, err := RenderFile(ctx, tf)
+	if err != nil {
+		return err
+	}
+	of := c.String("output")
+	if of == "" {
+		fmt.Print(out)
+		return nil
+	}
+	// New files take the template's permissions, so that rendered
+	// scripts stay executable.
+	fi, err := os.Stat(tf)
+	if err != nil {
+		return err
+	}
+	changed, err := WriteFileIfChanged(of, []byte(out), fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	return changedExit(c, changed)
+}
+
+// ChangedExitStatus is the exit status with --exit-code when a command
+// changed a file.  Errors exit with status 1.
+const ChangedExitStatus = 2
+
+var exitCodeFlag = cli.BoolFlag{
+	Name:  "exit-code",
+	Usage: "Exit with status 2 when a file changed",
+}
+
+func changedExit(c *cli.Context, changed bool) error {
+	if changed && c.Bool("exit-code") {
+		return cli.NewExitError("", ChangedExitStatus)
+	}
+	return nil
+}
+
+// newVersionContext builds the branch context for a command and records
+// the expanded version in it, so that commands can report the version
+// alongside other parameters.
+func newVersionContext(c *cli.Context) (*Context, error) {
+	vf, err := GetVersionFile(c)
+	if err != nil {
+		return nil, errors.New("version file required")
+	}
+
+	// Get options from command line
+	opts, err := getOptions(c)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, err := NewBranchContext(vf, c.GlobalString("rcs"), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	version, err := ExpandVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
 	ctx.State["version"] = version
+	return ctx, nil
+}
+
+// genFlags holds the flags for the GenOptions settings a generator can
+// accept.
+var genFlags = map[string]cli.Flag{
+	"package": cli.StringFlag{
+		Name:   "package",
+		Usage:  "Package name",
+		EnvVar: "GOPACKAGE",
+	},
+	"prefix": cli.StringFlag{
+		Name:  "prefix",
+		Usage: "Prefix for generated names",
+	},
+}
+
+func genCommands() []cli.Command {
+	cmds := []cli.Command{}
+	for _, g := range Generators {
+		flags := []cli.Flag{
+			cli.StringSliceFlag{
+				Name:  "option, X",
+				Usage: "Specified option",
+			},
+			cli.StringFlag{
+				Name:  "output, o",
+				Usage: "Output file",
+			},
+			exitCodeFlag,
+		}
+		for _, o := range g.Options {
+			flags = append(flags, genFlags[o])
+		}
+		cmds = append(cmds, cli.Command{
+			Name:   g.Name,
+			Usage:  g.Usage,
+			Flags:  flags,
+			Action: actionGen(g),
+		})
+	}
+	return cmds
+}
 
+func actionGen(g Generator) func(c *cli.Context) error {
+	return func(c *cli.Context) error {
+		ctx, err := newVersionContext(c)
+		if err != nil {
+			return err
+		}
+		fields, err := GenFields(ctx)
+		if err != nil {
+			return err
+		}
+		of := c.String("output")
+		opts := GenOptions{
+			Package: c.String("package"),
+			Prefix:  c.String("prefix"),
+		}
+		if of != "" {
+			opts.FileName = filepath.Base(of)
+		}
+		files, err := g.Generate(fields, opts)
+		if err != nil {
+			return err
+		}
+		if of == "" {
+			if len(files) > 1 {
+				return fmt.Errorf("gen %s writes several files and requires an output file", g.Name)
+			}
+			fmt.Print(string(files[0].Content))
+			return nil
+		}
+		changed := false
+		for _, f := range files {
+			fn := of
+			if f.Ext != "" {
+				fn = strings.TrimSuffix(of, filepath.Ext(of)) + f.Ext
+			}
+			written, err := WriteFileIfChanged(fn, f.Content, 0664)
+			if err != nil {
+				return err
+			}
+			changed = changed || written
+		}
+		return changedExit(c, changed)
+	}
+}
+
+// DataFileData looks up the data file fields from both the branch and the
+// top level configuration.
+func DataFileData(ctx *Context) (map[string]string, error) {
 	data := map[string]string{}
 	if ctx.BranchConfig.DataFileFields != nil {
 		for _, v := range ctx.BranchConfig.DataFileFields {
-			value, err := LookupParameter(v, &ctx)
+			value, err := LookupParameter(v, ctx)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			data[v] = value
 		}
 	}
 	for _, v := range ctx.Config.DataFileFields {
-		value, err := LookupParameter(v, &ctx)
+		value, err := LookupParameter(v, ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		data[v] = value
 	}
+	return data, nil
+}
 
-	if df == "" {
-		d, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			return err
+// NewBranchContext reads the version file and selects the branch config
+// matching the current branch.  A non-empty rcsBackend overrides the
+// version file's rcs setting.
+func NewBranchContext(vf string, rcsBackend string, opts []Option) (*Context, error) {
+	config, err := readConfig(vf)
+	if err != nil {
+		return nil, err
+	}
+	if config.Branches == nil {
+		return nil, fmt.Errorf("Could not parse branches")
+	}
+	if rcsBackend != "" {
+		if !IsRcsBackend(rcsBackend) {
+			return nil, fmt.Errorf("unknown rcs backend '%s'", rcsBackend)
 		}
-		fmt.Printf("%s\n", d)
-		return nil
+		config.Rcs = rcsBackend
 	}
-	err = writeDataFile(df, data)
+
+	ctx := NewContext(vf, config, opts)
+
+	// get branch from combination of supplied variables and lazy RCS
+	branch, err := LookupParameter("branch", &ctx)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
+
+	// locate appropriate branch config
+	// if branch does not match, error
+	branchConfig, branchParams, err := config.getBranchConfig(branch)
+	if err != nil {
+		return nil, err
+	}
+	ctx.BranchParams = *branchParams
+	ctx.BranchConfig = branchConfig
+	return &ctx, nil
 }
 
-func writeDataFile(filename string, dataFile map[string]string) error {
-	data, err := json.MarshalIndent(dataFile, "", "  ")
+func ExpandVersion(ctx *Context) (string, error) {
+	format, err := ParseString(ctx.BranchConfig.VersionTemplate)
 	if err != nil {
-		return err
+		return "", err
 	}
-	return ioutil.WriteFile(filename, data, 0664)
+	return format.Expand(ctx)
 }
 
 func actionBumpMajor(c *cli.Context) error {
@@ -494,9 +851,17 @@ func actionValidate(c *cli.Context) error {
 		return errors.New("version file required")
 	}
 
-	_, err := readConfig(vf)
+	config, err := readConfig(vf)
 	if err != nil {
 		return err
 	}
+	for _, scheme := range config.Packages {
+		for _, bc := range config.Branches {
+			err := checkPackageTemplate(config, bc, scheme)
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
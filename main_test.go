@@ -3,7 +3,10 @@ package main
 import (
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"testing"
+	"time"
 )
 
 func failWhenErr(t *testing.T, err error) {
@@ -19,6 +22,48 @@ func failWhen(t *testing.T, x bool) {
 	}
 }
 
+func skipWithoutCommand(t *testing.T, name string) {
+	_, err := exec.LookPath(name)
+	if err != nil {
+		t.Skipf("%s is not installed", name)
+	}
+}
+
+func runInDir(t *testing.T, dir string, name string, args ...string) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("%s %v failed: %s\n%s", name, args, err, out)
+	}
+}
+
+// chdirTemp moves the test into a fresh scratch directory and returns
+// a function that restores the original working directory.
+func chdirTemp(t *testing.T) (string, func()) {
+	wd, err := os.Getwd()
+	failWhenErr(t, err)
+	dir, err := ioutil.TempDir("", "vers-elsewhere")
+	failWhenErr(t, err)
+	failWhenErr(t, os.Chdir(dir))
+	return dir, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func writeVersionFile(t *testing.T, dir string, template string) {
+	c := Config{
+		Branches: []BranchConfig{{
+			BranchPattern:   ".*",
+			VersionTemplate: template,
+		},
+		},
+		DataFileFields: []string{},
+	}
+	failWhenErr(t, c.writeConfig(filepath.Join(dir, "version.json")))
+}
+
 func TestWriteInitFileProducesReadableFile(t *testing.T) {
 	tf, err := ioutil.TempFile("", "version.json")
 	failWhenErr(t, err)
@@ -66,7 +111,10 @@ func TestBranchConfig(t *testing.T) {
 		{"", "{branch}", "branch pattern required"},
 		{".*", "", "version template required"},
 		{"(", "{branch}", "branch pattern '(' is malformed"},
-		{".*", "{", "version template '{' is malformed"},
+		{".*", "{", "version template '{' is malformed: end of string malformed"},
+		{".*", "{branch|nope}", "version template '{branch|nope}' is malformed: unknown filter 'nope' at position 9"},
+		{".*", "{if dirty}x", "version template '{if dirty}x' is malformed: {if dirty} at position 1 is missing its {end}"},
+		{".*", "{if dirty}{version}{end}", "{version} cannot be contained in the version template"},
 	}
 	for _, tc := range testBranchConfig {
 		bc := BranchConfig{
@@ -105,3 +153,124 @@ func TestBranchParameterExtraction(t *testing.T) {
 	failWhenErr(t, err)
 	failWhen(t, len(*bp) != 0)
 }
+
+func TestBranchTypes(t *testing.T) {
+	c := Config{
+		Data: map[string]interface{}{
+			"release": 4,
+			"minor":   "2",
+			"name":    "vers",
+		},
+	}
+	var cases = []struct {
+		BranchPattern   string
+		VersionTemplate string
+		ErrorValue      string
+	}{
+		{".*", "{commit-counter - 1200}", ""},
+		{".*", "{release + 1}.{minor * 2}", ""},
+		{".*", "{build-id + 1}", ""},
+		{"rc-(?P<branch>.*)", "{branch + 1}", ""},
+		{".*", "{if dirty}{branch + 1}{end}", "version template '{if dirty}{branch + 1}{end}' uses branch in arithmetic but it is a string"},
+		{".*", "{name - 1}", "version template '{name - 1}' uses name in arithmetic but it is a string"},
+		{".*", "{dirty + 1}", "version template '{dirty + 1}' uses dirty in arithmetic but it is a boolean"},
+	}
+	for _, tc := range cases {
+		bc := BranchConfig{
+			BranchPattern:   tc.BranchPattern,
+			VersionTemplate: tc.VersionTemplate,
+		}
+		err := checkBranchTypes(&c, bc)
+		if tc.ErrorValue == "" {
+			failWhenErr(t, err)
+		} else if err == nil || err.Error() != tc.ErrorValue {
+			t.Log("Wanted ", tc.ErrorValue, ", Got: ", err)
+			t.Fail()
+		}
+	}
+}
+
+func TestBranchTypesUseBranchData(t *testing.T) {
+	c := Config{
+		Data: map[string]interface{}{"stage": 1},
+	}
+	bc := BranchConfig{
+		BranchPattern:   ".*",
+		VersionTemplate: "{stage + 1}",
+		Data:            map[string]interface{}{"stage": "beta"},
+	}
+	failWhen(t, checkBranchTypes(&c, bc) == nil)
+}
+
+func TestWriteFileIfChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vers-write")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	fn := filepath.Join(dir, "data.json")
+
+	changed, err := WriteFileIfChanged(fn, []byte("one"), 0600)
+	failWhenErr(t, err)
+	failWhen(t, !changed)
+	past := time.Now().Add(-time.Hour).Truncate(time.Second)
+	failWhenErr(t, os.Chtimes(fn, past, past))
+
+	changed, err = WriteFileIfChanged(fn, []byte("one"), 0664)
+	failWhenErr(t, err)
+	failWhen(t, changed)
+	fi, err := os.Stat(fn)
+	failWhenErr(t, err)
+	failWhen(t, !fi.ModTime().Equal(past))
+
+	changed, err = WriteFileIfChanged(fn, []byte("two"), 0664)
+	failWhenErr(t, err)
+	failWhen(t, !changed)
+	fi, err = os.Stat(fn)
+	failWhenErr(t, err)
+	failWhen(t, fi.Mode().Perm() != 0600)
+	data, err := ioutil.ReadFile(fn)
+	failWhenErr(t, err)
+	failWhen(t, string(data) != "two")
+
+	// No temporary files are left behind.
+	fs, err := ioutil.ReadDir(dir)
+	failWhenErr(t, err)
+	failWhen(t, len(fs) != 1)
+}
+
+func TestWriteFileIfChangedAppliesUmask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vers-write")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	// A new file gets the same permissions ioutil.WriteFile would give it.
+	reference := filepath.Join(dir, "reference.json")
+	failWhenErr(t, ioutil.WriteFile(reference, []byte("one"), 0666))
+	fn := filepath.Join(dir, "data.json")
+	_, err = WriteFileIfChanged(fn, []byte("one"), 0666)
+	failWhenErr(t, err)
+
+	want, err := os.Stat(reference)
+	failWhenErr(t, err)
+	fi, err := os.Stat(fn)
+	failWhenErr(t, err)
+	failWhen(t, fi.Mode().Perm() != want.Mode().Perm())
+}
+
+func TestWriteFileIfChangedFollowsSymlinks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vers-write")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	target := filepath.Join(dir, "real.json")
+	link := filepath.Join(dir, "data.json")
+	failWhenErr(t, ioutil.WriteFile(target, []byte("one"), 0600))
+	failWhenErr(t, os.Symlink("real.json", link))
+
+	changed, err := WriteFileIfChanged(link, []byte("two"), 0664)
+	failWhenErr(t, err)
+	failWhen(t, !changed)
+	fi, err := os.Lstat(link)
+	failWhenErr(t, err)
+	failWhen(t, fi.Mode()&os.ModeSymlink == 0)
+	data, err := ioutil.ReadFile(target)
+	failWhenErr(t, err)
+	failWhen(t, string(data) != "two")
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// PackageSchemes lists the package version schemes accepted in the version
+// file's packages section.
+var PackageSchemes = []string{"deb", "rpm"}
+
+func IsPackageScheme(scheme string) bool {
+	for _, s := range PackageSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+var versionPrefixPtrn = regexp.MustCompile("^[vV][0-9]")
+var invalidUpstreamPtrn = regexp.MustCompile("[^A-Za-z0-9.]+")
+
+// PackageUpstreamVersion translates a version into the upstream part of
+// a Debian or RPM version.  Both schemes sort ~ before anything else, so
+// a semver prerelease such as 1.0.0-rc1 becomes 1.0.0~rc1 and sorts
+// before 1.0.0.  Build metadata after + is kept, a leading v is dropped,
+// and any other unusable characters become periods.
+func PackageUpstreamVersion(version string) (string, error) {
+	v := version
+	if versionPrefixPtrn.MatchString(v) {
+		v = v[1:]
+	}
+	if v == "" || v[0] < '0' || v[0] > '9' {
+		return "", fmt.Errorf("version '%s' must start with a digit to be a package version", version)
+	}
+	build := ""
+	if i := strings.Index(v, "+"); i >= 0 {
+		build = v[i+1:]
+		v = v[:i]
+	}
+	pre := ""
+	if i := strings.Index(v, "-"); i >= 0 {
+		pre = v[i+1:]
+		v = v[:i]
+	}
+	upstream := invalidUpstreamPtrn.ReplaceAllString(v, ".")
+	if pre != "" {
+		upstream += "~" + invalidUpstreamPtrn.ReplaceAllString(pre, ".")
+	}
+	if build != "" {
+		upstream += "+" + invalidUpstreamPtrn.ReplaceAllString(build, ".")
+	}
+	return upstream, nil
+}
+
+var packageEpochPtrn = regexp.MustCompile("^[0-9]+$")
+var debianRevisionPtrn = regexp.MustCompile("^[A-Za-z0-9+.~]+$")
+var rpmReleasePtrn = regexp.MustCompile("^[A-Za-z0-9._+~^]+$")
+
+func checkPackageEpoch(epoch string) error {
+	if epoch != "" && !packageEpochPtrn.MatchString(epoch) {
+		return fmt.Errorf("epoch '%s' must be a number", epoch)
+	}
+	return nil
+}
+
+// DebianVersion produces [epoch:]upstream[-revision].
+func DebianVersion(version string, epoch string, revision string) (string, error) {
+	upstream, err := PackageUpstreamVersion(version)
+	if err != nil {
+		return "", err
+	}
+	err = checkPackageEpoch(epoch)
+	if err != nil {
+		return "", err
+	}
+	if revision != "" && !debianRevisionPtrn.MatchString(revision) {
+		return "", fmt.Errorf("revision '%s' may only contain letters, digits, and '+.~'", revision)
+	}
+	res := upstream
+	if epoch != "" {
+		res = epoch + ":" + res
+	}
+	if revision != "" {
+		res += "-" + revision
+	}
+	return res, nil
+}
+
+// RpmVersion holds the fields of an RPM version.  Epoch may be empty.
+type RpmVersion struct {
+	Epoch   string
+	Version string
+	Release string
+}
+
+func NewRpmVersion(version string, epoch string, release string) (RpmVersion, error) {
+	upstream, err := PackageUpstreamVersion(version)
+	if err != nil {
+		return RpmVersion{}, err
+	}
+	err = checkPackageEpoch(epoch)
+	if err != nil {
+		return RpmVersion{}, err
+	}
+	if !rpmReleasePtrn.MatchString(release) {
+		return RpmVersion{}, fmt.Errorf("release '%s' may only contain letters, digits, and '._+~^'", release)
+	}
+	return RpmVersion{Epoch: epoch, Version: upstream, Release: release}, nil
+}
+
+// SpecFields renders the version as spec file preamble fields.
+func (v RpmVersion) SpecFields() string {
+	res := ""
+	if v.Epoch != "" {
+		res += "Epoch: " + v.Epoch + "\n"
+	}
+	return res + "Version: " + v.Version + "\nRelease: " + v.Release + "\n"
+}
+
+// unnumberedParameters never start with a digit, so a version cannot
+// start with them.
+var unnumberedParameters = map[string]bool{
+	"branch":       true,
+	"repo-root":    true,
+	"dirty":        true,
+	"dirty-suffix": true,
+}
+
+// checkPackageTemplate reports version templates whose versions can never
+// start with a digit, and so can never become package versions.  Leading
+// components whose values cannot be known until the build are assumed to
+// be fine.
+func checkPackageTemplate(c *Config, bc BranchConfig, scheme string) error {
+	t, err := ParseString(bc.VersionTemplate)
+	if err != nil {
+		return err
+	}
+	lead := unnumberedLead(c, bc, t.Components, false)
+	if lead == "" {
+		return nil
+	}
+	return fmt.Errorf("version template '%s' cannot produce %s versions because it starts with %s", bc.VersionTemplate, scheme, lead)
+}
+
+// unnumberedLead describes the leading component of nodes when it cannot
+// start with a digit, or returns an empty string.  A leading v is allowed
+// before the digit, and afterV is true when it has already been seen.
+// Conditionals and defaults are checked along each of their alternatives.
+func unnumberedLead(c *Config, bc BranchConfig, nodes []TemplateNode, afterV bool) string {
+	if len(nodes) == 0 {
+		if afterV {
+			return "'v' alone"
+		}
+		return "nothing"
+	}
+	rest := nodes[1:]
+	// checkWith continues the check with nodes in place of the leading
+	// node, copying them so that the template itself is left alone.
+	checkWith := func(lead ...TemplateNode) string {
+		return unnumberedLead(c, bc, append(append([]TemplateNode{}, lead...), rest...), afterV)
+	}
+	switch n := nodes[0].(type) {
+	case StringLiteralNode:
+		v := n.Value
+		if !afterV && (strings.HasPrefix(v, "v") || strings.HasPrefix(v, "V")) {
+			v = v[1:]
+			afterV = true
+		}
+		if v == "" {
+			return unnumberedLead(c, bc, rest, afterV)
+		}
+		if v[0] < '0' || v[0] > '9' {
+			return fmt.Sprintf("'%s'", n.Value)
+		}
+	case *ExpansionNode:
+		if unnumberedParameters[n.Name] {
+			return fmt.Sprintf("{%s}", n.Name)
+		}
+		if c.ParameterType(n.Name, bc) != TYPE_STRING {
+			return ""
+		}
+		v, ok := bc.Data[n.Name]
+		if !ok {
+			v, ok = c.Data[n.Name]
+		}
+		s, isString := v.(string)
+		if ok && isString && !afterV && versionPrefixPtrn.MatchString(s) {
+			return ""
+		}
+		if ok && isString && (s == "" || s[0] < '0' || s[0] > '9') {
+			return fmt.Sprintf("{%s}", n.Name)
+		}
+	case *ZeroFillExpansionNode, *DateExpansionNode, *ExpressionNode:
+		// Date formats start with a directive, and every directive is
+		// numeric.
+		return ""
+	case *DefaultExpansionNode:
+		if lead := checkWith(&ExpansionNode{Name: n.Name}); lead != "" {
+			return lead
+		}
+		return checkWith(StringLiteralNode{Value: n.Default})
+	case *DefaultFilterNode:
+		if lead := checkWith(n.Node); lead != "" {
+			return lead
+		}
+		return checkWith(StringLiteralNode{Value: n.Value})
+	case *ConditionalNode:
+		if lead := checkWith(n.Then...); lead != "" {
+			return lead
+		}
+		return checkWith(n.Else...)
+	case *LowerFilterNode:
+		return checkWith(n.Node)
+	case *UpperFilterNode:
+		return checkWith(n.Node)
+	case *SlugFilterNode:
+		return checkWith(n.Node)
+	case *TruncFilterNode:
+		return checkWith(n.Node)
+	case *ReplaceFilterNode:
+		return checkWith(n.Node)
+	default:
+		// Anything new is assumed to be able to start with a non-digit
+		// until it is handled here.
+		return fmt.Sprintf("{%s}", strings.Join(n.Vars(), ", "))
+	}
+	return ""
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"testing"
+)
+
+var upstreamVersionTests = []struct {
+	Version  string
+	Upstream string
+}{
+	{"1.0.0", "1.0.0"},
+	{"v1.0.0", "1.0.0"},
+	{"1.0.0-rc1", "1.0.0~rc1"},
+	{"1.0.0-rc.1-dirty", "1.0.0~rc.1.dirty"},
+	{"1.0.0+build.5", "1.0.0+build.5"},
+	{"1.0.0-beta+exp_sha", "1.0.0~beta+exp.sha"},
+	{"2017.07.14_feature/x", "2017.07.14.feature.x"},
+}
+
+func TestPackageUpstreamVersion(t *testing.T) {
+	for _, tc := range upstreamVersionTests {
+		u, err := PackageUpstreamVersion(tc.Version)
+		failWhenErr(t, err)
+		if u != tc.Upstream {
+			t.Errorf("%s: wanted %s but got %s", tc.Version, tc.Upstream, u)
+		}
+	}
+}
+
+func TestPackageUpstreamVersionRequiresDigit(t *testing.T) {
+	for _, v := range []string{"master.15-dirty", "", "v", "vx1"} {
+		_, err := PackageUpstreamVersion(v)
+		if err == nil {
+			t.Errorf("expected '%s' to be rejected", v)
+		}
+	}
+}
+
+var debianVersionTests = []struct {
+	Version  string
+	Epoch    string
+	Revision string
+	Expected string
+}{
+	{"1.0.0", "", "", "1.0.0"},
+	{"1.0.0-rc1", "", "1", "1.0.0~rc1-1"},
+	{"1.0.0", "2", "3ubuntu1", "2:1.0.0-3ubuntu1"},
+}
+
+func TestDebianVersion(t *testing.T) {
+	for _, tc := range debianVersionTests {
+		v, err := DebianVersion(tc.Version, tc.Epoch, tc.Revision)
+		failWhenErr(t, err)
+		if v != tc.Expected {
+			t.Errorf("%s: wanted %s but got %s", tc.Version, tc.Expected, v)
+		}
+	}
+	_, err := DebianVersion("1.0", "x", "")
+	failWhen(t, err == nil)
+	_, err = DebianVersion("1.0", "", "a-b")
+	failWhen(t, err == nil)
+}
+
+func TestRpmVersion(t *testing.T) {
+	v, err := NewRpmVersion("1.0.0-rc1", "1", "2")
+	failWhenErr(t, err)
+	failWhen(t, v.SpecFields() != "Epoch: 1\nVersion: 1.0.0~rc1\nRelease: 2\n")
+	v, err = NewRpmVersion("1.0.0", "", "1")
+	failWhenErr(t, err)
+	failWhen(t, v.SpecFields() != "Version: 1.0.0\nRelease: 1\n")
+	_, err = NewRpmVersion("1.0.0", "", "1-2")
+	failWhen(t, err == nil)
+}
+
+var packageTemplateTests = []struct {
+	Template string
+	Data     map[string]interface{}
+	Valid    bool
+}{
+	{"{major}.{minor}.{release}", map[string]interface{}{"major": 1, "minor": 0, "release": 0}, true},
+	{"v{major}.{minor}", map[string]interface{}{"major": 1, "minor": 0}, true},
+	{"{commit-counter}", nil, true},
+	{"{commit-time:%Y.%m.%d}", nil, true},
+	{"{last-tag}-{tag-distance}", nil, true},
+	{"{build-id}", nil, true},
+	{"{branch}.{commit-counter}", nil, false},
+	{"release-{commit-counter}", nil, false},
+	{"v", nil, false},
+	{"{name}-{commit-counter}", map[string]interface{}{"name": "vers"}, false},
+	{"{prefix}{commit-counter}", map[string]interface{}{"prefix": "v2."}, true},
+	{"{branch|lower}.{commit-counter}", nil, false},
+	{"{branch|slug|trunc:20}", nil, false},
+	{"{commit-counter|default:0}", nil, true},
+	{"{build-id|default:local}", nil, false},
+	{"{build-id:-0}.{commit-counter}", nil, true},
+	{"{build-id:-local}", nil, false},
+	{"{?dirty:x}1.0", nil, false},
+	{"{?dirty:1}2.0", nil, true},
+	{"{?dirty:1}.0", nil, false},
+	{"{if dirty}1{else}2{end}.0", nil, true},
+	{"{if dirty}1{else}dev{end}.0", nil, false},
+	{"{if dirty}{end}v{commit-counter}", nil, true},
+	{"{commit-counter + 1}", nil, true},
+	{"{commit-counter:04d}", nil, true},
+}
+
+func TestCheckPackageTemplate(t *testing.T) {
+	for _, tc := range packageTemplateTests {
+		c := Config{Data: tc.Data}
+		bc := BranchConfig{BranchPattern: ".*", VersionTemplate: tc.Template}
+		err := checkPackageTemplate(&c, bc, "deb")
+		if (err == nil) != tc.Valid {
+			t.Errorf("%s: expected valid=%v but got %v", tc.Template, tc.Valid, err)
+		}
+	}
+}
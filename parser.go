@@ -3,6 +3,7 @@ package main
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -38,22 +39,120 @@ func (t *Template) Variables() []string {
 	return vars
 }
 
+func (t *Template) IntegerVariables() []string {
+	exp := map[string]bool{}
+	for _, n := range t.Components {
+		for _, v := range n.IntVars() {
+			exp[v] = true
+		}
+	}
+	vars := []string{}
+	for v := range exp {
+		vars = append(vars, v)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
 func ParseString(template string) (Template, error) {
+	return parseTokens(Tokenize(template))
+}
+
+// ParseText parses a whole file as a template.  Files such as scripts
+// and spec files are full of backslashes, so a backslash is only an
+// escape when it precedes '{' or another backslash.
+func ParseText(text string) (Template, error) {
+	tokens := make(chan Token)
+	go runTokenizer(text, tokens, true)
+	return parseTokens(tokens)
+}
+
+func parseTokens(tokens chan Token) (Template, error) {
+	// Drain the tokenizer so that it can exit whenever parsing stops
+	// early.
+	defer func() {
+		for range tokens {
+		}
+	}()
 	tmpl := Template{
 		Components: []TemplateNode{},
 	}
-	for t := range Tokenize(template) {
+	nodes, end, err := parseComponents(tokens)
+	if err != nil {
+		return tmpl, err
+	}
+	if end.Kind == TOKEN_ELSE {
+		return tmpl, fmt.Errorf("{else} without matching {if} at position %d", end.Pos)
+	} else if end.Kind == TOKEN_END {
+		return tmpl, fmt.Errorf("{end} without matching {if} at position %d", end.Pos)
+	}
+	tmpl.Components = nodes
+	return tmpl, nil
+}
+
+// parseComponents collects nodes until the token stream ends or reaches
+// an {else} or {end}, which is returned so that the caller can close the
+// enclosing conditional.  The returned token's kind is TOKEN_EOF when the
+// stream ended.
+func parseComponents(tokens chan Token) ([]TemplateNode, Token, error) {
+	nodes := []TemplateNode{}
+	for t := range tokens {
 		if t.Kind == TOKEN_ERROR {
-			return tmpl, t.Err
+			return nodes, t, t.Err
 		} else if t.Kind == TOKEN_STRING {
-			tmpl.Components = append(tmpl.Components, StringLiteralNode{Value: t.Value})
-		} else if t.Kind == TOKEN_VAR {
-			tmpl.Components = append(tmpl.Components, NewExpansionNode(t.Value))
+			nodes = append(nodes, StringLiteralNode{Value: t.Value})
+		} else if t.Kind == TOKEN_VAR || t.Kind == TOKEN_EXPR {
+			var node TemplateNode
+			if t.Kind == TOKEN_VAR {
+				node = NewExpansionNode(t.Value)
+			} else {
+				var err error
+				node, err = NewExpressionNode(t.Value)
+				if err != nil {
+					return nodes, t, err
+				}
+			}
+			for _, f := range t.Filters {
+				var err error
+				node, err = NewFilterNode(node, f)
+				if err != nil {
+					return nodes, t, err
+				}
+			}
+			nodes = append(nodes, node)
+		} else if t.Kind == TOKEN_COND {
+			nodes = append(nodes, &ConditionalNode{
+				Name: t.Value,
+				Then: []TemplateNode{StringLiteralNode{Value: t.Text}},
+				Else: []TemplateNode{},
+			})
+		} else if t.Kind == TOKEN_IF {
+			cond := ConditionalNode{Name: t.Value, Else: []TemplateNode{}}
+			then, end, err := parseComponents(tokens)
+			if err != nil {
+				return nodes, end, err
+			}
+			cond.Then = then
+			if end.Kind == TOKEN_ELSE {
+				cond.Else, end, err = parseComponents(tokens)
+				if err != nil {
+					return nodes, end, err
+				}
+				if end.Kind == TOKEN_ELSE {
+					return nodes, end, fmt.Errorf("second {else} for {if %s} at position %d", t.Value, end.Pos)
+				}
+			}
+			if end.Kind != TOKEN_END {
+				return nodes, end, fmt.Errorf("{if %s} at position %d is missing its {end}", t.Value, t.Pos)
+			}
+			nodes = append(nodes, &cond)
+		} else if t.Kind == TOKEN_ELSE || t.Kind == TOKEN_END {
+			return nodes, t, nil
 		} else {
 			panic("unknown token type found during parsing")
 		}
 	}
-	return tmpl, nil
+	return nodes, Token{Kind: TOKEN_EOF}, nil
 }
 
 func Tokenize(template string) chan Token {
@@ -66,6 +165,12 @@ const (
 	TOKEN_STRING = iota
 	TOKEN_VAR    = iota
 	TOKEN_ERROR  = iota
+	TOKEN_COND   = iota
+	TOKEN_IF     = iota
+	TOKEN_ELSE   = iota
+	TOKEN_END    = iota
+	TOKEN_EOF    = iota
+	TOKEN_EXPR   = iota
 )
 
 const (
@@ -77,17 +182,48 @@ const (
 	STATE_SPECIFIER_FIELD_WIDTH = iota
 	STATE_SPECIFIER_DECIMAL     = iota
 	STATE_NAME_COMPLETE         = iota
+	STATE_DATE_FORMAT           = iota
+	STATE_DATE_DIRECTIVE        = iota
+	STATE_DATE_DIRECTIVE_FLAG   = iota
+	STATE_FILTER_NAME_FIRST     = iota
+	STATE_FILTER_NAME           = iota
+	STATE_FILTER_ARG            = iota
+	STATE_FILTER_ARG_ESCAPE     = iota
+	STATE_COND_NAME_FIRST       = iota
+	STATE_COND_NAME             = iota
+	STATE_COND_TEXT             = iota
+	STATE_COND_TEXT_ESCAPE      = iota
+	STATE_IF_NAME_FIRST         = iota
+	STATE_IF_NAME               = iota
+	STATE_EXPR                  = iota
+	STATE_DEFAULT_VALUE         = iota
+	STATE_DEFAULT_VALUE_ESCAPE  = iota
+	STATE_OPTIONAL              = iota
 )
 
 func RunTokenizer(template string, out chan Token) {
+	runTokenizer(template, out, false)
+}
+
+// runTokenizer tokenizes a template.  When literalBackslashes is true, a
+// backslash that does not escape anything is kept as text rather than
+// rejected.
+func runTokenizer(template string, out chan Token, literalBackslashes bool) {
 	t := ""
+	filters := []FilterSpec{}
+	text := ""
+	isExpr := false
 	state := STATE_STRING
+	pos := 0
+	start := 0
 	defer close(out)
 	for _, r := range template {
+		pos++
 		if state == STATE_STRING {
 			if r == '{' {
 				out <- StringToken(t)
 				t = ""
+				start = pos
 				state = STATE_NAME_FIRST
 			} else if r == '\\' {
 				state = STATE_STRING_ESCAPE
@@ -99,40 +235,87 @@ func RunTokenizer(template string, out chan Token) {
 			if r == '{' || r == '\\' {
 				t = t + string(r)
 				state = STATE_STRING
+			} else if literalBackslashes {
+				t = t + "\\" + string(r)
+				state = STATE_STRING
 			} else {
-				out <- ErrorToken("unknown escape code")
+				out <- ErrorTokenAt(pos, "unknown escape code")
 				return
 			}
 		} else if state == STATE_NAME_FIRST {
 			if r == '}' {
-				out <- ErrorToken("variable not defined")
+				out <- ErrorTokenAt(pos, "variable not defined")
 				return
+			} else if r == '?' {
+				state = STATE_COND_NAME_FIRST
+			} else if unicode.IsDigit(r) || r == '(' || r == '-' {
+				t = t + string(r)
+				isExpr = true
+				state = STATE_EXPR
 			} else if unicode.IsLetter(r) {
 				t = t + string(r)
 				state = STATE_NAME_AFTER
 			} else {
-				out <- ErrorToken("variable nume must start with letters")
+				out <- ErrorTokenAt(pos, "variable name must start with letters")
+				return
 			}
 		} else if state == STATE_NAME_AFTER {
-			if r == '}' {
-				out <- VarToken(t)
+			if r == '}' && t == "else" {
+				out <- Token{Kind: TOKEN_ELSE, Pos: start}
 				t = ""
 				state = STATE_STRING
+			} else if r == '}' && t == "end" {
+				out <- Token{Kind: TOKEN_END, Pos: start}
+				t = ""
+				state = STATE_STRING
+			} else if r == '}' {
+				if isExpr {
+					out <- ExprToken(t, filters)
+				} else {
+					out <- VarToken(t, filters)
+				}
+				t = ""
+				filters = []FilterSpec{}
+				isExpr = false
+				state = STATE_STRING
+			} else if r == ' ' && t == "if" {
+				t = ""
+				state = STATE_IF_NAME_FIRST
+			} else if r == ' ' {
+				t = t + string(r)
+				isExpr = true
+				state = STATE_EXPR
+			} else if r == '?' {
+				// {name?} is shorthand for an empty default, {name:-}
+				t = t + ":-"
+				state = STATE_OPTIONAL
 			} else if r == ':' {
 				t = t + string(r)
 				state = STATE_SPECIFIER_ZERO_FILL
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
 			} else if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
 				t = t + string(r)
 				state = STATE_NAME_AFTER
 			} else {
-				out <- ErrorToken("variable nume must start with letters")
+				out <- ErrorTokenAt(pos, fmt.Sprintf("unexpected '%c' in variable name", r))
+				return
 			}
 		} else if state == STATE_SPECIFIER_ZERO_FILL {
 			if r == '0' {
 				t = t + string(r)
 				state = STATE_SPECIFIER_FIELD_WIDTH
+			} else if (r == '%' || r == '-') && isExpr {
+				out <- ErrorTokenAt(pos, "only zero fill allowed in expression specifier")
+				return
+			} else if r == '%' {
+				t = t + string(r)
+				state = STATE_DATE_DIRECTIVE
+			} else if r == '-' {
+				t = t + string(r)
+				state = STATE_DEFAULT_VALUE
 			} else {
-				out <- ErrorToken("only zero fill allowed in specifier")
+				out <- ErrorTokenAt(pos, "only zero fill, date format, or default allowed in specifier")
 				return
 			}
 		} else if state == STATE_SPECIFIER_FIELD_WIDTH {
@@ -140,7 +323,7 @@ func RunTokenizer(template string, out chan Token) {
 				t = t + string(r)
 				state = STATE_SPECIFIER_DECIMAL
 			} else {
-				out <- ErrorToken("only digit allowed in field width")
+				out <- ErrorTokenAt(pos, "only digit allowed in field width")
 				return
 			}
 		} else if state == STATE_SPECIFIER_DECIMAL {
@@ -148,22 +331,248 @@ func RunTokenizer(template string, out chan Token) {
 				t = t + string(r)
 				state = STATE_NAME_COMPLETE
 			} else {
-				out <- ErrorToken("d expected as field type specifier")
+				out <- ErrorTokenAt(pos, "d expected as field type specifier")
 				return
 			}
 		} else if state == STATE_NAME_COMPLETE {
 			if r == '}' {
-				out <- VarToken(t)
+				if isExpr {
+					out <- ExprToken(t, filters)
+				} else {
+					out <- VarToken(t, filters)
+				}
+				t = ""
+				filters = []FilterSpec{}
+				isExpr = false
+				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else {
+				out <- ErrorTokenAt(pos, "d expected as field type specifier")
+				return
+			}
+		} else if state == STATE_DATE_FORMAT {
+			if r == '}' {
+				if isExpr {
+					out <- ExprToken(t, filters)
+				} else {
+					out <- VarToken(t, filters)
+				}
 				t = ""
+				filters = []FilterSpec{}
+				isExpr = false
 				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else if r == '%' {
+				t = t + string(r)
+				state = STATE_DATE_DIRECTIVE
+			} else {
+				t = t + string(r)
+				state = STATE_DATE_FORMAT
+			}
+		} else if state == STATE_DATE_DIRECTIVE {
+			if r == '-' {
+				t = t + string(r)
+				state = STATE_DATE_DIRECTIVE_FLAG
+			} else if r == '%' || IsStrftimeDirective(r) {
+				t = t + string(r)
+				state = STATE_DATE_FORMAT
 			} else {
-				out <- ErrorToken("d expected as field type specifier")
+				out <- ErrorTokenAt(pos, fmt.Sprintf("unknown date directive '%%%c'", r))
+				return
+			}
+		} else if state == STATE_DATE_DIRECTIVE_FLAG {
+			if IsStrftimeDirective(r) {
+				t = t + string(r)
+				state = STATE_DATE_FORMAT
+			} else {
+				out <- ErrorTokenAt(pos, fmt.Sprintf("unknown date directive '%%-%c'", r))
+				return
+			}
+		} else if state == STATE_FILTER_NAME_FIRST {
+			if unicode.IsLetter(r) {
+				filters = append(filters, FilterSpec{Name: string(r), Args: []string{}, Pos: pos})
+				state = STATE_FILTER_NAME
+			} else {
+				out <- ErrorTokenAt(pos, "filter name must start with letters")
+				return
+			}
+		} else if state == STATE_FILTER_NAME {
+			f := &filters[len(filters)-1]
+			if r == '}' {
+				if isExpr {
+					out <- ExprToken(t, filters)
+				} else {
+					out <- VarToken(t, filters)
+				}
+				t = ""
+				filters = []FilterSpec{}
+				isExpr = false
+				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else if r == ':' {
+				f.Args = append(f.Args, "")
+				state = STATE_FILTER_ARG
+			} else if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+				f.Name = f.Name + string(r)
+				state = STATE_FILTER_NAME
+			} else {
+				out <- ErrorTokenAt(pos, fmt.Sprintf("unexpected '%c' in filter name", r))
+				return
+			}
+		} else if state == STATE_FILTER_ARG {
+			f := &filters[len(filters)-1]
+			if r == '}' {
+				if isExpr {
+					out <- ExprToken(t, filters)
+				} else {
+					out <- VarToken(t, filters)
+				}
+				t = ""
+				filters = []FilterSpec{}
+				isExpr = false
+				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else if r == ':' {
+				f.Args = append(f.Args, "")
+				state = STATE_FILTER_ARG
+			} else if r == '\\' {
+				state = STATE_FILTER_ARG_ESCAPE
+			} else {
+				f.Args[len(f.Args)-1] = f.Args[len(f.Args)-1] + string(r)
+				state = STATE_FILTER_ARG
+			}
+		} else if state == STATE_FILTER_ARG_ESCAPE {
+			f := &filters[len(filters)-1]
+			if strings.ContainsRune("\\:|{}", r) {
+				f.Args[len(f.Args)-1] = f.Args[len(f.Args)-1] + string(r)
+				state = STATE_FILTER_ARG
+			} else {
+				out <- ErrorTokenAt(pos, "unknown escape code in filter argument")
+				return
+			}
+		} else if state == STATE_DEFAULT_VALUE {
+			if r == '}' {
+				out <- VarToken(t, filters)
+				t = ""
+				filters = []FilterSpec{}
+				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else if r == '\\' {
+				state = STATE_DEFAULT_VALUE_ESCAPE
+			} else {
+				t = t + string(r)
+				state = STATE_DEFAULT_VALUE
+			}
+		} else if state == STATE_DEFAULT_VALUE_ESCAPE {
+			if strings.ContainsRune("\\|{}", r) {
+				t = t + string(r)
+				state = STATE_DEFAULT_VALUE
+			} else {
+				out <- ErrorTokenAt(pos, "unknown escape code in default value")
+				return
+			}
+		} else if state == STATE_OPTIONAL {
+			if r == '}' {
+				out <- VarToken(t, filters)
+				t = ""
+				filters = []FilterSpec{}
+				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else {
+				out <- ErrorTokenAt(pos, "expected '}' after '?'")
+				return
+			}
+		} else if state == STATE_EXPR {
+			if r == '}' {
+				out <- ExprToken(t, filters)
+				t = ""
+				filters = []FilterSpec{}
+				isExpr = false
+				state = STATE_STRING
+			} else if r == '|' {
+				state = STATE_FILTER_NAME_FIRST
+			} else if r == ':' {
+				t = t + string(r)
+				state = STATE_SPECIFIER_ZERO_FILL
+			} else if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("-+*/%() ", r) {
+				t = t + string(r)
+				state = STATE_EXPR
+			} else {
+				out <- ErrorTokenAt(pos, fmt.Sprintf("unexpected '%c' in expression", r))
+				return
+			}
+		} else if state == STATE_COND_NAME_FIRST {
+			if unicode.IsLetter(r) {
+				t = t + string(r)
+				state = STATE_COND_NAME
+			} else {
+				out <- ErrorTokenAt(pos, "variable name must start with letters")
+				return
+			}
+		} else if state == STATE_COND_NAME {
+			if r == ':' {
+				text = ""
+				state = STATE_COND_TEXT
+			} else if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+				t = t + string(r)
+				state = STATE_COND_NAME
+			} else {
+				out <- ErrorTokenAt(pos, "expected ':' after conditional variable name")
+				return
+			}
+		} else if state == STATE_COND_TEXT {
+			if r == '}' {
+				out <- Token{Kind: TOKEN_COND, Value: t, Text: text, Pos: start}
+				t = ""
+				state = STATE_STRING
+			} else if r == '\\' {
+				state = STATE_COND_TEXT_ESCAPE
+			} else {
+				text = text + string(r)
+				state = STATE_COND_TEXT
+			}
+		} else if state == STATE_COND_TEXT_ESCAPE {
+			if r == '{' || r == '}' || r == '\\' {
+				text = text + string(r)
+				state = STATE_COND_TEXT
+			} else {
+				out <- ErrorTokenAt(pos, "unknown escape code in conditional text")
+				return
+			}
+		} else if state == STATE_IF_NAME_FIRST {
+			if unicode.IsLetter(r) {
+				t = t + string(r)
+				state = STATE_IF_NAME
+			} else {
+				out <- ErrorTokenAt(pos, "variable name must start with letters")
+				return
+			}
+		} else if state == STATE_IF_NAME {
+			if r == '}' {
+				out <- Token{Kind: TOKEN_IF, Value: t, Pos: start}
+				t = ""
+				state = STATE_STRING
+			} else if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '-' {
+				t = t + string(r)
+				state = STATE_IF_NAME
+			} else {
+				out <- ErrorTokenAt(pos, fmt.Sprintf("unexpected '%c' in variable name", r))
 				return
 			}
 		} else {
 			panic("unreachable state")
 		}
 	}
+	if state == STATE_STRING_ESCAPE && literalBackslashes {
+		t = t + "\\"
+		state = STATE_STRING
+	}
 	if state == STATE_STRING {
 		if t != "" {
 			out <- StringToken(t)
@@ -174,9 +583,20 @@ func RunTokenizer(template string, out chan Token) {
 }
 
 type Token struct {
-	Kind  int
-	Value string
-	Err   error
+	Kind    int
+	Value   string
+	Filters []FilterSpec
+	Text    string
+	Pos     int
+	Err     error
+}
+
+// FilterSpec is a filter as written in a template, e.g. trunc:20.  Pos
+// locates it in the template for error messages.
+type FilterSpec struct {
+	Name string
+	Args []string
+	Pos  int
 }
 
 func StringToken(value string) Token {
@@ -187,11 +607,21 @@ func StringToken(value string) Token {
 	}
 }
 
-func VarToken(value string) Token {
+func VarToken(value string, filters []FilterSpec) Token {
 	return Token{
-		Kind:  TOKEN_VAR,
-		Value: value,
-		Err:   nil,
+		Kind:    TOKEN_VAR,
+		Value:   value,
+		Filters: filters,
+		Err:     nil,
+	}
+}
+
+func ExprToken(value string, filters []FilterSpec) Token {
+	return Token{
+		Kind:    TOKEN_EXPR,
+		Value:   value,
+		Filters: filters,
+		Err:     nil,
 	}
 }
 
@@ -203,9 +633,15 @@ func ErrorToken(msg string) Token {
 	}
 }
 
+func ErrorTokenAt(pos int, msg string) Token {
+	return ErrorToken(fmt.Sprintf("%s at position %d", msg, pos))
+}
+
 type TemplateNode interface {
 	Expand(c *Context) (string, error)
 	Vars() []string
+	// IntVars lists the variables which must hold integers.
+	IntVars() []string
 }
 
 type StringLiteralNode struct {
@@ -220,14 +656,23 @@ func (n StringLiteralNode) Vars() []string {
 	return []string{}
 }
 
+func (n StringLiteralNode) IntVars() []string {
+	return []string{}
+}
+
 type ExpansionNode struct {
 	Name string
 }
 
 func NewExpansionNode(varExpr string) TemplateNode {
-	parts := strings.Split(varExpr, ":")
+	// Date formats may contain colons, so only the first one counts.
+	parts := strings.SplitN(varExpr, ":", 2)
 	if len(parts) == 1 {
 		return &ExpansionNode{Name: parts[0]}
+	} else if strings.HasPrefix(parts[1], "%") {
+		return &DateExpansionNode{Name: parts[0], Format: parts[1]}
+	} else if strings.HasPrefix(parts[1], "-") {
+		return &DefaultExpansionNode{Name: parts[0], Default: parts[1][1:]}
 	} else if len(parts) == 2 {
 		name := parts[0]
 		widthSpeciferRune := []rune(parts[1])[1]
@@ -244,15 +689,43 @@ func NewExpansionNode(varExpr string) TemplateNode {
 func (n ExpansionNode) Expand(c *Context) (string, error) {
 	value, err := LookupParameter(n.Name, c)
 	if err != nil {
-		return "", fmt.Errorf("could not expand %s", n.Name)
+		return "", ExpansionError{Name: n.Name, Err: err}
 	}
 	return value, nil
 }
 
+// ExpansionError reports a parameter that could not be looked up while
+// keeping the cause, so that defaults can tell unknown parameters apart
+// from failures.
+type ExpansionError struct {
+	Name string
+	Err  error
+}
+
+func (e ExpansionError) Error() string {
+	return fmt.Sprintf("could not expand %s", e.Name)
+}
+
+func (e ExpansionError) Unwrap() error {
+	return e.Err
+}
+
+// IsMissingParameter reports whether err comes from looking up a
+// parameter that does not exist or has no value here.
+func IsMissingParameter(err error) bool {
+	var unknown UnknownParameterError
+	var unavailable UnavailableParameterError
+	return errors.As(err, &unknown) || errors.As(err, &unavailable)
+}
+
 func (n ExpansionNode) Vars() []string {
 	return []string{n.Name}
 }
 
+func (n ExpansionNode) IntVars() []string {
+	return []string{}
+}
+
 type ZeroFillExpansionNode struct {
 	Name       string
 	FieldWidth int
@@ -261,7 +734,7 @@ type ZeroFillExpansionNode struct {
 func (n ZeroFillExpansionNode) Expand(c *Context) (string, error) {
 	value, err := LookupParameter(n.Name, c)
 	if err != nil {
-		return "", fmt.Errorf("could not expand %s", n.Name)
+		return "", ExpansionError{Name: n.Name, Err: err}
 	}
 	numValue, err := strconv.Atoi(value)
 	if err != nil {
@@ -274,3 +747,123 @@ func (n ZeroFillExpansionNode) Expand(c *Context) (string, error) {
 func (n ZeroFillExpansionNode) Vars() []string {
 	return []string{n.Name}
 }
+
+func (n ZeroFillExpansionNode) IntVars() []string {
+	return []string{}
+}
+
+type DateExpansionNode struct {
+	Name   string
+	Format string
+}
+
+func (n DateExpansionNode) Expand(c *Context) (string, error) {
+	value, err := LookupParameter(n.Name, c)
+	if err != nil {
+		return "", ExpansionError{Name: n.Name, Err: err}
+	}
+	t, err := ParseTimeParameter(value)
+	if err != nil {
+		return "", err
+	}
+	return Strftime(t, n.Format)
+}
+
+func (n DateExpansionNode) Vars() []string {
+	return []string{n.Name}
+}
+
+func (n DateExpansionNode) IntVars() []string {
+	return []string{}
+}
+
+// ConditionalNode expands Then when the named parameter is truthy, and
+// Else otherwise.
+type ConditionalNode struct {
+	Name string
+	Then []TemplateNode
+	Else []TemplateNode
+}
+
+func (n ConditionalNode) Expand(c *Context) (string, error) {
+	truthy, err := IsParameterTruthy(n.Name, c)
+	if err != nil {
+		return "", err
+	}
+	nodes := n.Else
+	if truthy {
+		nodes = n.Then
+	}
+	res := ""
+	for _, node := range nodes {
+		exp, err := node.Expand(c)
+		if err != nil {
+			return "", err
+		}
+		res = res + exp
+	}
+	return res, nil
+}
+
+func (n ConditionalNode) Vars() []string {
+	vars := []string{n.Name}
+	for _, node := range n.Then {
+		vars = append(vars, node.Vars()...)
+	}
+	for _, node := range n.Else {
+		vars = append(vars, node.Vars()...)
+	}
+	return vars
+}
+
+func (n ConditionalNode) IntVars() []string {
+	vars := []string{}
+	for _, node := range n.Then {
+		vars = append(vars, node.IntVars()...)
+	}
+	for _, node := range n.Else {
+		vars = append(vars, node.IntVars()...)
+	}
+	return vars
+}
+
+// IsParameterTruthy treats parameters without values as unset, whether
+// they are unknown or unavailable here, such as tag-distance without a
+// tag.  Empty values, 0, and false are also false.
+func IsParameterTruthy(name string, c *Context) (bool, error) {
+	v, err := LookupParameter(name, c)
+	if err != nil {
+		if IsMissingParameter(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if v == "" || v == "false" {
+		return false, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err == nil && n == 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// DefaultExpansionNode behaves like the shell's ${name:-default},
+// substituting the default when the parameter is unknown or empty.  It
+// is the default filter applied to a plain expansion.
+type DefaultExpansionNode struct {
+	Name    string
+	Default string
+}
+
+func (n DefaultExpansionNode) Expand(c *Context) (string, error) {
+	return DefaultFilterNode{Node: ExpansionNode{Name: n.Name}, Value: n.Default}.Expand(c)
+}
+
+func (n DefaultExpansionNode) Vars() []string {
+	return []string{n.Name}
+}
+
+func (n DefaultExpansionNode) IntVars() []string {
+	return []string{}
+}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestParseAndExpandPlainString(t *testing.T) {
@@ -21,6 +24,10 @@ func TestParseAndExpandPlainString(t *testing.T) {
 		{"{x:02d}.{y:02d}", map[string]string{"x": "1", "y": "2"}, "01.02"},
 		{"{foo}", map[string]string{"foo": "FOO"}, "FOO"},
 		{"{f1}{f2}", map[string]string{"f1": "3", "f2": "4"}, "34"},
+		{"{t:%Y.%m.%d}", map[string]string{"t": "2026-10-17T23:30:00Z"}, "2026.10.17"},
+		{"{t:%Y.%-m.%-d}.{n}", map[string]string{"t": "2026-01-05T00:00:00Z", "n": "3"}, "2026.1.5.3"},
+		{"{t:%H:%M}", map[string]string{"t": "1500000000"}, "02:40"},
+		{"{t:%y%%}", map[string]string{"t": "1500000000"}, "17%"},
 	}
 	for _, tc := range cases {
 		tmpl, err := ParseString(tc.Template)
@@ -36,3 +43,326 @@ func TestParseAndExpandPlainString(t *testing.T) {
 		failWhen(t, x != tc.Want)
 	}
 }
+
+func TestParseMalformedDateFormats(t *testing.T) {
+	for _, tmpl := range []string{"{t:%Q}", "{t:%-%}", "{t:%Y", "{t:x}"} {
+		_, err := ParseString(tmpl)
+		failWhen(t, err == nil)
+	}
+}
+
+func TestParseAndExpandFilters(t *testing.T) {
+	var cases = []struct {
+		Template  string
+		Expansion map[string]string
+		Want      string
+	}{
+		{"{b|lower}", map[string]string{"b": "Feature/JIRA-123"}, "feature/jira-123"},
+		{"{b|upper}", map[string]string{"b": "Feature/JIRA-123"}, "FEATURE/JIRA-123"},
+		{"{b|slug}", map[string]string{"b": "feature/JIRA-123_Fix Thing"}, "feature-JIRA-123-Fix-Thing"},
+		{"{b|slug:.}", map[string]string{"b": "--a  b--"}, "a.b"},
+		{"{b|lower|slug|trunc:12}", map[string]string{"b": "feature/JIRA-123_Fix Thing"}, "feature-jira"},
+		{"{b|trunc:20}", map[string]string{"b": "short"}, "short"},
+		{"{b|trunc:0}", map[string]string{"b": "short"}, ""},
+		{"{b|replace:/:-}", map[string]string{"b": "a/b/c"}, "a-b-c"},
+		{"{b|replace:\\::.}", map[string]string{"b": "a:b"}, "a.b"},
+		{"{b|replace:\\|:\\}}", map[string]string{"b": "a|b"}, "a}b"},
+		{"{b|default:local}", map[string]string{"b": ""}, "local"},
+		{"{missing|default:local}", map[string]string{}, "local"},
+		{"{missing|default:}", map[string]string{}, ""},
+		{"{b|default:local|upper}", map[string]string{"b": "ci"}, "CI"},
+		{"{n:03d|replace:0:o}", map[string]string{"n": "7"}, "oo7"},
+		{"{t:%Y.%m|replace:.:-}", map[string]string{"t": "2026-10-17T00:00:00Z"}, "2026-10"},
+		{"v{b|slug}-{n}", map[string]string{"b": "x y", "n": "1"}, "vx-y-1"},
+	}
+	for _, tc := range cases {
+		tmpl, err := ParseString(tc.Template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State:        tc.Expansion,
+			BranchConfig: &BranchConfig{},
+		}
+		x, err := tmpl.Expand(&ctx)
+		failWhenErr(t, err)
+		if x != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Template, tc.Want, x)
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	var cases = []struct {
+		Template string
+		Err      string
+	}{
+		{"{b|}", "filter name must start with letters at position 4"},
+		{"{b|9}", "filter name must start with letters at position 4"},
+		{"{b|lo wer}", "unexpected ' ' in filter name at position 6"},
+		{"{b|shout}", "unknown filter 'shout' at position 4"},
+		{"{b|lower:x}", "filter lower at position 4 expects 0 arguments but got 1"},
+		{"{b|trunc}", "filter trunc at position 4 expects 1 arguments but got 0"},
+		{"{b|trunc:x}", "filter trunc at position 4 expects a non-negative integer but got 'x'"},
+		{"{b|upper|replace:a}", "filter replace at position 10 expects 2 arguments but got 1"},
+		{"{b|replace::x}", "filter replace at position 4 cannot replace an empty string"},
+		{"{b|slug:a:b}", "filter slug at position 4 expects 0 to 1 arguments but got 2"},
+		{"{b|replace:\\n:x}", "unknown escape code in filter argument at position 13"},
+		{"{b|lower", "end of string malformed"},
+	}
+	for _, tc := range cases {
+		_, err := ParseString(tc.Template)
+		if err == nil || err.Error() != tc.Err {
+			t.Errorf("%s: wanted error '%s' but got '%v'", tc.Template, tc.Err, err)
+		}
+	}
+}
+
+func TestParseAndExpandConditionals(t *testing.T) {
+	clean := map[string]string{"v": "1.2.3", "dirty": "false", "tag-distance": "0", "n": "45", "h": "abc"}
+	dirty := map[string]string{"v": "1.2.3", "dirty": "true", "tag-distance": "45", "n": "45", "h": "abc"}
+	var cases = []struct {
+		Template  string
+		Expansion map[string]string
+		Want      string
+	}{
+		{"{v}{?dirty:-dirty}", clean, "1.2.3"},
+		{"{v}{?dirty:-dirty}", dirty, "1.2.3-dirty"},
+		{"{v}{?missing:-x}", clean, "1.2.3"},
+		{"{v}{?h:+\\{\\}}", clean, "1.2.3+{}"},
+		{"{v}{if tag-distance}-dev.{n}+g{h}{end}", clean, "1.2.3"},
+		{"{v}{if tag-distance}-dev.{n}+g{h}{end}", dirty, "1.2.3-dev.45+gabc"},
+		{"{if dirty}dev{else}rel{end}-{v}", clean, "rel-1.2.3"},
+		{"{if dirty}dev{else}rel{end}-{v}", dirty, "dev-1.2.3"},
+		{"{if dirty}{if tag-distance}a{else}b{end}{else}c{end}", dirty, "a"},
+		{"{if missing}{nope}{end}", clean, ""},
+		{"{if v}{end}", clean, ""},
+	}
+	for _, tc := range cases {
+		tmpl, err := ParseString(tc.Template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State: tc.Expansion,
+		}
+		x, err := tmpl.Expand(&ctx)
+		failWhenErr(t, err)
+		if x != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Template, tc.Want, x)
+		}
+	}
+}
+
+func TestParseConditionalErrors(t *testing.T) {
+	var cases = []struct {
+		Template string
+		Err      string
+	}{
+		{"{if dirty}x", "{if dirty} at position 1 is missing its {end}"},
+		{"a{end}", "{end} without matching {if} at position 2"},
+		{"a{else}b", "{else} without matching {if} at position 2"},
+		{"{if x}a{else}b{else}c{end}", "second {else} for {if x} at position 15"},
+		{"{if x}{if y}{end}", "{if x} at position 1 is missing its {end}"},
+		{"{if 1}{end}", "variable name must start with letters at position 5"},
+		{"{?dirty}", "expected ':' after conditional variable name at position 8"},
+		{"{?dirty:-dirty", "end of string malformed"},
+		{"{if x}{b|nope}{end}", "unknown filter 'nope' at position 10"},
+	}
+	for _, tc := range cases {
+		_, err := ParseString(tc.Template)
+		if err == nil || err.Error() != tc.Err {
+			t.Errorf("%s: wanted error '%s' but got '%v'", tc.Template, tc.Err, err)
+		}
+	}
+}
+
+func TestUnmatchedEndDoesNotLeakTokenizer(t *testing.T) {
+	before := runtime.NumGoroutine()
+	for i := 0; i < 100; i++ {
+		_, err := ParseString("a{end}b{c}d{else}e")
+		failWhen(t, err == nil)
+	}
+	// Drained tokenizers may still be returning, so allow some slack.
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("parsing left %d goroutines running", after-before)
+	}
+}
+
+func TestConditionalVariables(t *testing.T) {
+	tmpl, err := ParseString("{if a}{b}{else}{?c:x}{end}")
+	failWhenErr(t, err)
+	vars := map[string]bool{}
+	for _, v := range tmpl.Variables() {
+		vars[v] = true
+	}
+	failWhen(t, len(vars) != 3 || !vars["a"] || !vars["b"] || !vars["c"])
+}
+
+func TestParseAndExpandArithmetic(t *testing.T) {
+	params := map[string]string{"commit-counter": "1245", "release": "2", "x": "7", "neg": "-3"}
+	var cases = []struct {
+		Template string
+		Want     string
+	}{
+		{"{commit-counter - 1200}", "45"},
+		{"{release + 1}", "3"},
+		{"{1 + release}", "3"},
+		{"{x * 2 + 1}", "15"},
+		{"{x * (2 + 1)}", "21"},
+		{"{(x + 1) / 3}", "2"},
+		{"{x % 4}", "3"},
+		{"{-x}", "-7"},
+		{"{neg - 1}", "-4"},
+		{"{release + 1:03d}", "003"},
+		{"{release + 1|replace:3:three}", "three"},
+		{"{if x}{x - 7}{end}", "0"},
+	}
+	for _, tc := range cases {
+		tmpl, err := ParseString(tc.Template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State: params,
+		}
+		x, err := tmpl.Expand(&ctx)
+		failWhenErr(t, err)
+		if x != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Template, tc.Want, x)
+		}
+	}
+}
+
+func TestParseArithmeticErrors(t *testing.T) {
+	var cases = []struct {
+		Template string
+		Err      string
+	}{
+		{"{x + }", "unexpected end in expression 'x + '"},
+		{"{x + + }", "unexpected '+' in expression 'x + + '"},
+		{"{(x + 1}", "missing ')' in expression '(x + 1'"},
+		{"{x 1}", "unexpected '1' in expression 'x 1'"},
+		{"{x / 0}", "division by zero in expression 'x / 0'"},
+		{"{x + 1:%Y}", "only zero fill allowed in expression specifier at position 8"},
+		{"{x & 1}", "unexpected '&' in expression at position 4"},
+		{"{x+1}", "unexpected '+' in variable name at position 3"},
+	}
+	for _, tc := range cases {
+		_, err := ParseString(tc.Template)
+		if err == nil || err.Error() != tc.Err {
+			t.Errorf("%s: wanted error '%s' but got '%v'", tc.Template, tc.Err, err)
+		}
+	}
+}
+
+func TestExpandArithmeticErrors(t *testing.T) {
+	var cases = []struct {
+		Template string
+		Err      string
+	}{
+		{"{b + 1}", "could not read b value 'main' as integer"},
+		{"{x / zero}", "division by zero"},
+	}
+	for _, tc := range cases {
+		tmpl, err := ParseString(tc.Template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State: map[string]string{"b": "main", "x": "1", "zero": "0"},
+		}
+		_, err = tmpl.Expand(&ctx)
+		if err == nil || err.Error() != tc.Err {
+			t.Errorf("%s: wanted error '%s' but got '%v'", tc.Template, tc.Err, err)
+		}
+	}
+}
+
+func TestParseAndExpandDefaults(t *testing.T) {
+	var cases = []struct {
+		Template  string
+		Expansion map[string]string
+		Want      string
+	}{
+		{"b{build-id:-local}", map[string]string{}, "blocal"},
+		{"b{build-id:-local}", map[string]string{"build-id": "42"}, "b42"},
+		{"b{build-id:-local}", map[string]string{"build-id": ""}, "blocal"},
+		{"b{build-id?}", map[string]string{}, "b"},
+		{"b{build-id?}", map[string]string{"build-id": "42"}, "b42"},
+		{"{x:-}", map[string]string{}, ""},
+		{"{x:-a:b-c}", map[string]string{}, "a:b-c"},
+		{"{x:-\\}\\|\\\\}", map[string]string{}, "}|\\"},
+		{"{x:-Local|upper}", map[string]string{}, "LOCAL"},
+		{"{x?|default:none}", map[string]string{}, "none"},
+		{"{x?|upper}", map[string]string{"x": "y"}, "Y"},
+	}
+	for _, tc := range cases {
+		tmpl, err := ParseString(tc.Template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State: tc.Expansion,
+		}
+		x, err := tmpl.Expand(&ctx)
+		failWhenErr(t, err)
+		if x != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Template, tc.Want, x)
+		}
+	}
+}
+
+func TestDefaultsPassLookupFailuresThrough(t *testing.T) {
+	for _, template := range []string{"{branch:-main}", "{branch|default:main}", "{branch|upper|default:main}"} {
+		tmpl, err := ParseString(template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State: map[string]string{},
+			Rcs:   RcsMissing{Err: errors.New("no repository")},
+		}
+		_, err = tmpl.Expand(&ctx)
+		if err == nil {
+			t.Errorf("%s: expected the repository error to pass through", template)
+		}
+	}
+}
+
+func TestParseDefaultErrors(t *testing.T) {
+	var cases = []struct {
+		Template string
+		Err      string
+	}{
+		{"{x?y}", "expected '}' after '?' at position 4"},
+		{"{x:-\\n}", "unknown escape code in default value at position 6"},
+		{"{x + 1:-2}", "only zero fill allowed in expression specifier at position 8"},
+		{"{x:-abc", "end of string malformed"},
+		{"{x:y}", "only zero fill, date format, or default allowed in specifier at position 4"},
+	}
+	for _, tc := range cases {
+		_, err := ParseString(tc.Template)
+		if err == nil || err.Error() != tc.Err {
+			t.Errorf("%s: wanted error '%s' but got '%v'", tc.Template, tc.Err, err)
+		}
+	}
+}
+
+func TestParseTextKeepsLoneBackslashes(t *testing.T) {
+	var cases = []struct {
+		Template  string
+		Expansion map[string]string
+		Want      string
+	}{
+		{"printf '%s\\n' {x}\n", map[string]string{"x": "1.0"}, "printf '%s\\n' 1.0\n"},
+		{"%\\{name} {x}", map[string]string{"x": "1.0"}, "%{name} 1.0"},
+		{"a\\\\b", map[string]string{}, "a\\b"},
+		{"trailing\\", map[string]string{}, "trailing\\"},
+		{"{x|replace:\\::-}", map[string]string{"x": "a:b"}, "a-b"},
+	}
+	for _, tc := range cases {
+		tmpl, err := ParseText(tc.Template)
+		failWhenErr(t, err)
+		ctx := Context{
+			State: tc.Expansion,
+		}
+		x, err := tmpl.Expand(&ctx)
+		failWhenErr(t, err)
+		if x != tc.Want {
+			t.Errorf("wanted %q but got %q", tc.Want, x)
+		}
+	}
+	// Version templates still reject them.
+	_, err := ParseString("a\\nb")
+	failWhen(t, err == nil)
+}
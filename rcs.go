@@ -2,34 +2,144 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"time"
 )
 
-func GetRcs(versionFile string) (Rcs, error) {
-	_, ok := os.LookupEnv("TRAVIS_BRANCH")
-	if ok {
-		return RcsTravis{}, nil
+// GetRcs locates the repository containing the version file.  The
+// config's rcs setting selects between alternative implementations for
+// the same kind of repository.  Under a recognized CI system the
+// repository's backend is wrapped so that build details come from the CI
+// environment, and the ci-precedence setting decides which source wins
+// when both have a value.
+func GetRcs(versionFile string, c *Config) (Rcs, error) {
+	rcs, err := getRepoRcs(versionFile, c.Rcs)
+	if c.CiPrecedence == CiPrecedenceIgnore {
+		return rcs, err
 	}
+	custom, cerr := c.CiProviders()
+	if cerr != nil {
+		return nil, cerr
+	}
+	p, ok := DetectCiProvider(custom)
+	if !ok {
+		return rcs, err
+	}
+	// CI builds can still report what the environment knows without a
+	// checkout.
+	if err != nil {
+		rcs = RcsMissing{Err: err}
+	}
+	return RcsCi{Provider: p, Rcs: rcs, Precedence: c.CiPrecedence}, nil
+}
+
+func getRepoRcs(versionFile string, preferred string) (Rcs, error) {
 	dn, err := FindInPath(IsRcsDir, versionFile)
 	if err != nil {
 		return nil, err
 	}
+	return getDirRcs(dn, preferred)
+}
+
+func getDirRcs(dn string, preferred string) (Rcs, error) {
 	fis, err := ioutil.ReadDir(dn)
 	if err != nil {
 		return nil, err
 	}
+	found := map[string]bool{}
 	for _, fi := range fis {
-		if fi.Name() == ".git" {
-			return RcsGit{Root: dn}, nil
+		found[fi.Name()] = true
+	}
+	// A preferred backend picks between colocated repositories, and a
+	// choice that can't be honoured is an error rather than a surprise.
+	switch preferred {
+	case "":
+	case "jj":
+		if found[".jj"] {
+			return RcsJj{Root: dn}, nil
 		}
-		if fi.Name() == ".svn" {
+		return nil, missingCheckoutError(dn, preferred, ".jj")
+	case "git", "git-native":
+		if found[".git"] {
+			return GetGitRcs(dn, preferred), nil
+		}
+		return nil, missingCheckoutError(dn, preferred, ".git")
+	case "svn":
+		if found[".svn"] {
 			return RcsSvn{Root: dn}, nil
 		}
+		return nil, missingCheckoutError(dn, preferred, ".svn")
+	case "hg":
+		if found[".hg"] {
+			return RcsHg{Root: dn}, nil
+		}
+		return nil, missingCheckoutError(dn, preferred, ".hg")
+	case "fossil":
+		if found[".fslckout"] || found["_FOSSIL_"] {
+			return RcsFossil{Root: dn}, nil
+		}
+		return nil, missingCheckoutError(dn, preferred, ".fslckout")
+	default:
+		return nil, fmt.Errorf("unknown rcs backend '%s'", preferred)
+	}
+	// Jujutsu is usually colocated with git, and then it is the tool
+	// being used unless jj isn't installed.
+	if found[".jj"] && !(found[".git"] && !hasCommand("jj")) {
+		return RcsJj{Root: dn}, nil
+	}
+	if found[".git"] {
+		return GetGitRcs(dn, ""), nil
+	}
+	if found[".svn"] {
+		return RcsSvn{Root: dn}, nil
+	}
+	if found[".hg"] {
+		return RcsHg{Root: dn}, nil
+	}
+	if found[".fslckout"] || found["_FOSSIL_"] {
+		return RcsFossil{Root: dn}, nil
 	}
 	return nil, errors.New("could not locates RCS root containing version file")
 }
 
+func missingCheckoutError(dn string, backend string, marker string) error {
+	return fmt.Errorf("rcs backend '%s' was chosen but %s has no %s checkout", backend, dn, marker)
+}
+
+func hasCommand(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// GetGitRcs uses the git binary unless the native reader is preferred,
+// or git is not installed.
+func GetGitRcs(root string, preferred string) Rcs {
+	if preferred == "git-native" {
+		return NewRcsGitNative(root)
+	}
+	if preferred == "" {
+		_, err := exec.LookPath("git")
+		if err != nil {
+			return NewRcsGitNative(root)
+		}
+	}
+	return RcsGit{Root: root}
+}
+
+var RcsBackends = []string{"git", "git-native", "jj", "svn", "hg", "fossil"}
+
+func IsRcsBackend(name string) bool {
+	for _, b := range RcsBackends {
+		if b == name {
+			return true
+		}
+	}
+	return false
+}
+
 type Rcs interface {
 	Name() string
 	Branch() (string, error)
@@ -38,4 +148,56 @@ type Rcs interface {
 	RepoRoot() (string, error)
 	CommitHash() (string, error)
 	CommitHashShort() (string, error)
+	LastTag() (string, error)
+	TagDistance() (string, error)
+	ExactTag() (string, error)
+	Dirty() (bool, error)
+	CommitTime() (time.Time, error)
 }
+
+// RunRcsCommand runs an RCS client in the repository's root so that
+// results describe the repository containing the version file rather
+// than whatever directory vers was started from.
+func RunRcsCommand(root string, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Dir = root
+	// Output is parsed, and so are some error messages, so they must not
+	// be translated.
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	// Output leaves stderr in the *exec.ExitError so that callers can
+	// tell expected failures from real ones.
+	out, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+// NoTagError reports that no tag is reachable from the current commit,
+// which leaves last-tag and tag-distance without values.
+type NoTagError struct {
+	Reason string
+}
+
+func (e NoTagError) Error() string {
+	return e.Reason
+}
+
+// RcsMissing stands in for a repository that could not be found, and
+// reports why for every request.
+type RcsMissing struct {
+	Err error
+}
+
+func (v RcsMissing) Name() string                     { return "" }
+func (v RcsMissing) Branch() (string, error)          { return "", v.Err }
+func (v RcsMissing) CommitCounter() (string, error)   { return "", v.Err }
+func (v RcsMissing) RepoCounter() (string, error)     { return "", v.Err }
+func (v RcsMissing) RepoRoot() (string, error)        { return "", v.Err }
+func (v RcsMissing) CommitHash() (string, error)      { return "", v.Err }
+func (v RcsMissing) CommitHashShort() (string, error) { return "", v.Err }
+func (v RcsMissing) LastTag() (string, error)         { return "", v.Err }
+func (v RcsMissing) TagDistance() (string, error)     { return "", v.Err }
+func (v RcsMissing) ExactTag() (string, error)        { return "", v.Err }
+func (v RcsMissing) Dirty() (bool, error)             { return false, v.Err }
+func (v RcsMissing) CommitTime() (time.Time, error)   { return time.Time{}, v.Err }
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+)
+
+// EnvSource reads a value from an environment variable.  When Pattern is
+// set the value is its first capture group, and a value that does not
+// match counts as missing.
+type EnvSource struct {
+	Var     string
+	Pattern *regexp.Regexp
+}
+
+func (s EnvSource) Value() (string, bool) {
+	v := os.Getenv(s.Var)
+	if v == "" {
+		return "", false
+	}
+	if s.Pattern == nil {
+		return v, true
+	}
+	m := s.Pattern.FindStringSubmatch(v)
+	if m == nil || m[1] == "" {
+		return "", false
+	}
+	return m[1], true
+}
+
+// firstEnvValue returns the first value found among sources.
+func firstEnvValue(sources []EnvSource) (string, bool) {
+	for _, s := range sources {
+		v, ok := s.Value()
+		if ok {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func envVar(name string) EnvSource {
+	return EnvSource{Var: name}
+}
+
+func envVarMatch(name string, ptrn string) EnvSource {
+	return EnvSource{Var: name, Pattern: regexp.MustCompile(ptrn)}
+}
+
+const (
+	CiPrecedenceEnvironment = "environment"
+	CiPrecedenceRepository  = "repository"
+	CiPrecedenceIgnore      = "ignore"
+)
+
+// CiPrecedences lists the values of the ci-precedence setting.  The
+// environment wins by default, the repository can be preferred instead,
+// or the CI environment can be ignored altogether.
+var CiPrecedences = []string{CiPrecedenceEnvironment, CiPrecedenceRepository, CiPrecedenceIgnore}
+
+func IsCiPrecedence(name string) bool {
+	for _, p := range CiPrecedences {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// layeredValue chooses between a value from the CI environment and one
+// from the repository.  A repository that cannot answer, or whose
+// detached checkout reports HEAD as the branch, defers to the environment
+// even when the repository is preferred.
+func layeredValue(precedence string, ev string, envOk bool, repo func() (string, error)) (string, error) {
+	if precedence != CiPrecedenceRepository {
+		if envOk {
+			return ev, nil
+		}
+		return repo()
+	}
+	rv, err := repo()
+	if err == nil && rv != "" && rv != "HEAD" {
+		return rv, nil
+	}
+	if envOk {
+		return ev, nil
+	}
+	return rv, err
+}
+
+// CiParameters lists the parameters that a CI provider may supply.
+var CiParameters = []string{
+	"branch",
+	"commit-hash",
+	"commit-counter",
+	"exact-tag",
+	"build-number",
+	"pr-number",
+	"pr-source-branch",
+	"pr-target-branch",
+}
+
+func IsCiParameter(name string) bool {
+	for _, p := range CiParameters {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// CiProvider describes where a CI system publishes build details in the
+// environment.  Detect must produce a value for the provider to be used.
+// Each parameter's sources are tried in order, so pull request sources
+// come before the ordinary branch.
+type CiProvider struct {
+	Name    string
+	Detect  EnvSource
+	Sources map[string][]EnvSource
+}
+
+func (p CiProvider) Value(parameter string) (string, bool) {
+	return firstEnvValue(p.Sources[parameter])
+}
+
+var CiProviders = []CiProvider{
+	{
+		Name:   "travis",
+		Detect: envVar("TRAVIS_BRANCH"),
+		Sources: map[string][]EnvSource{
+			"branch": {
+				envVar("TRAVIS_PULL_REQUEST_BRANCH"),
+				envVar("TRAVIS_BRANCH"),
+			},
+			"commit-hash":  {envVar("TRAVIS_COMMIT")},
+			"exact-tag":    {envVar("TRAVIS_TAG")},
+			"build-number": {envVar("TRAVIS_BUILD_NUMBER")},
+			// Travis sets this to "false" outside of pull requests.
+			"pr-number":        {envVarMatch("TRAVIS_PULL_REQUEST", "^([0-9]+)$")},
+			"pr-source-branch": {envVar("TRAVIS_PULL_REQUEST_BRANCH")},
+			// Pull request builds report the target as the branch.
+			"pr-target-branch": {envVar("TRAVIS_BRANCH")},
+		},
+	},
+	{
+		Name:   "github",
+		Detect: envVarMatch("GITHUB_ACTIONS", "^(true)$"),
+		Sources: map[string][]EnvSource{
+			"branch": {
+				envVar("GITHUB_HEAD_REF"),
+				envVarMatch("GITHUB_REF", "^refs/heads/(.+)$"),
+			},
+			"commit-hash":      {envVar("GITHUB_SHA")},
+			"exact-tag":        {envVarMatch("GITHUB_REF", "^refs/tags/(.+)$")},
+			"build-number":     {envVar("GITHUB_RUN_NUMBER")},
+			"pr-number":        {envVarMatch("GITHUB_REF", "^refs/pull/([0-9]+)/")},
+			"pr-source-branch": {envVar("GITHUB_HEAD_REF")},
+			"pr-target-branch": {envVar("GITHUB_BASE_REF")},
+		},
+	},
+	{
+		Name:   "gitlab",
+		Detect: envVarMatch("GITLAB_CI", "^(true)$"),
+		Sources: map[string][]EnvSource{
+			"branch": {
+				envVar("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME"),
+				envVar("CI_COMMIT_BRANCH"),
+			},
+			"commit-hash":      {envVar("CI_COMMIT_SHA")},
+			"exact-tag":        {envVar("CI_COMMIT_TAG")},
+			"build-number":     {envVar("CI_PIPELINE_IID")},
+			"pr-number":        {envVar("CI_MERGE_REQUEST_IID")},
+			"pr-source-branch": {envVar("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME")},
+			"pr-target-branch": {envVar("CI_MERGE_REQUEST_TARGET_BRANCH_NAME")},
+		},
+	},
+	{
+		Name:   "jenkins",
+		Detect: envVar("JENKINS_URL"),
+		Sources: map[string][]EnvSource{
+			"branch": {
+				envVar("CHANGE_BRANCH"),
+				envVar("BRANCH_NAME"),
+				envVarMatch("GIT_BRANCH", "^(?:origin/)?(.+)$"),
+			},
+			"commit-hash":      {envVar("GIT_COMMIT")},
+			"exact-tag":        {envVar("TAG_NAME")},
+			"build-number":     {envVar("BUILD_NUMBER")},
+			"pr-number":        {envVar("CHANGE_ID")},
+			"pr-source-branch": {envVar("CHANGE_BRANCH")},
+			"pr-target-branch": {envVar("CHANGE_TARGET")},
+		},
+	},
+	{
+		Name:   "circleci",
+		Detect: envVarMatch("CIRCLECI", "^(true)$"),
+		Sources: map[string][]EnvSource{
+			"branch":       {envVar("CIRCLE_BRANCH")},
+			"commit-hash":  {envVar("CIRCLE_SHA1")},
+			"exact-tag":    {envVar("CIRCLE_TAG")},
+			"build-number": {envVar("CIRCLE_BUILD_NUM")},
+			"pr-number": {
+				envVar("CIRCLE_PR_NUMBER"),
+				envVarMatch("CIRCLE_PULL_REQUEST", "/pull/([0-9]+)$"),
+			},
+		},
+	},
+	{
+		Name:   "buildkite",
+		Detect: envVarMatch("BUILDKITE", "^(true)$"),
+		Sources: map[string][]EnvSource{
+			"branch":       {envVar("BUILDKITE_BRANCH")},
+			"commit-hash":  {envVar("BUILDKITE_COMMIT")},
+			"exact-tag":    {envVar("BUILDKITE_TAG")},
+			"build-number": {envVar("BUILDKITE_BUILD_NUMBER")},
+			// Buildkite sets this to "false" outside of pull requests.
+			"pr-number":        {envVarMatch("BUILDKITE_PULL_REQUEST", "^([0-9]+)$")},
+			"pr-target-branch": {envVar("BUILDKITE_PULL_REQUEST_BASE_BRANCH")},
+		},
+	},
+	{
+		Name:   "azure",
+		Detect: envVarMatch("TF_BUILD", "^(?i)(true)$"),
+		Sources: map[string][]EnvSource{
+			"branch": {
+				envVarMatch("SYSTEM_PULLREQUEST_SOURCEBRANCH", "^(?:refs/heads/)?(.+)$"),
+				envVarMatch("BUILD_SOURCEBRANCH", "^refs/heads/(.+)$"),
+			},
+			"commit-hash":  {envVar("BUILD_SOURCEVERSION")},
+			"exact-tag":    {envVarMatch("BUILD_SOURCEBRANCH", "^refs/tags/(.+)$")},
+			"build-number": {envVar("BUILD_BUILDID")},
+			"pr-number": {
+				envVar("SYSTEM_PULLREQUEST_PULLREQUESTNUMBER"),
+				envVar("SYSTEM_PULLREQUEST_PULLREQUESTID"),
+			},
+			"pr-source-branch": {envVarMatch("SYSTEM_PULLREQUEST_SOURCEBRANCH", "^(?:refs/heads/)?(.+)$")},
+			"pr-target-branch": {envVarMatch("SYSTEM_PULLREQUEST_TARGETBRANCH", "^(?:refs/heads/)?(.+)$")},
+		},
+	},
+	{
+		Name:   "bitbucket",
+		Detect: envVar("BITBUCKET_BUILD_NUMBER"),
+		Sources: map[string][]EnvSource{
+			"branch":           {envVar("BITBUCKET_BRANCH")},
+			"commit-hash":      {envVar("BITBUCKET_COMMIT")},
+			"exact-tag":        {envVar("BITBUCKET_TAG")},
+			"build-number":     {envVar("BITBUCKET_BUILD_NUMBER")},
+			"pr-number":        {envVar("BITBUCKET_PR_ID")},
+			"pr-target-branch": {envVar("BITBUCKET_PR_DESTINATION_BRANCH")},
+		},
+	},
+}
+
+// DetectCiProvider returns the first provider whose detection variable is
+// set.  Custom providers from the version file are checked before the
+// built in ones so that they can replace them.
+func DetectCiProvider(custom []CiProvider) (CiProvider, bool) {
+	for _, providers := range [][]CiProvider{custom, CiProviders} {
+		for _, p := range providers {
+			_, ok := p.Detect.Value()
+			if ok {
+				return p, true
+			}
+		}
+	}
+	return CiProvider{}, false
+}
+
+// EnvSourceConfig is an environment source in the version file.
+type EnvSourceConfig struct {
+	Var     string `json:"var"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+func (sc EnvSourceConfig) EnvSource() (EnvSource, error) {
+	if sc.Var == "" {
+		return EnvSource{}, errors.New("environment source requires a var")
+	}
+	if sc.Pattern == "" {
+		return EnvSource{Var: sc.Var}, nil
+	}
+	ptrn, err := regexp.Compile(sc.Pattern)
+	if err != nil {
+		return EnvSource{}, fmt.Errorf("cannot compile pattern for %s: %s", sc.Var, err)
+	}
+	if ptrn.NumSubexp() < 1 {
+		return EnvSource{}, fmt.Errorf("pattern '%s' for %s needs a capture group", sc.Pattern, sc.Var)
+	}
+	return EnvSource{Var: sc.Var, Pattern: ptrn}, nil
+}
+
+// EnvironmentConfig describes a custom CI provider in the version file's
+// environment section.
+type EnvironmentConfig struct {
+	Name       string                       `json:"name"`
+	Detect     EnvSourceConfig              `json:"detect"`
+	Parameters map[string][]EnvSourceConfig `json:"parameters"`
+}
+
+func (ec EnvironmentConfig) CiProvider() (CiProvider, error) {
+	if ec.Name == "" {
+		return CiProvider{}, errors.New("environment requires a name")
+	}
+	detect, err := ec.Detect.EnvSource()
+	if err != nil {
+		return CiProvider{}, fmt.Errorf("environment %s detect: %s", ec.Name, err)
+	}
+	p := CiProvider{Name: ec.Name, Detect: detect, Sources: map[string][]EnvSource{}}
+	for param, scs := range ec.Parameters {
+		if !IsCiParameter(param) {
+			return CiProvider{}, fmt.Errorf("environment %s cannot supply parameter '%s'", ec.Name, param)
+		}
+		for _, sc := range scs {
+			src, err := sc.EnvSource()
+			if err != nil {
+				return CiProvider{}, fmt.Errorf("environment %s %s: %s", ec.Name, param, err)
+			}
+			p.Sources[param] = append(p.Sources[param], src)
+		}
+	}
+	return p, nil
+}
+
+// CiProviders converts the version file's environment section.
+func (c *Config) CiProviders() ([]CiProvider, error) {
+	providers := []CiProvider{}
+	for _, ec := range c.Environment {
+		p, err := ec.CiProvider()
+		if err != nil {
+			return nil, err
+		}
+		providers = append(providers, p)
+	}
+	return providers, nil
+}
+
+// CiEnvironment is implemented by backends that know about the CI build
+// they are running in.
+type CiEnvironment interface {
+	CiProvider() string
+	BuildNumber() (string, error)
+	PrNumber() (string, error)
+	PrSourceBranch() (string, error)
+	PrTargetBranch() (string, error)
+}
+
+// RcsCi takes the parameters that the CI provider supplies from the
+// environment, which is more reliable than a CI checkout's detached HEAD,
+// and leaves everything else to the repository's own backend.
+type RcsCi struct {
+	Provider   CiProvider
+	Rcs        Rcs
+	Precedence string
+}
+
+func (v RcsCi) Name() string {
+	return v.Rcs.Name()
+}
+
+func (v RcsCi) CiProvider() string {
+	return v.Provider.Name
+}
+
+func (v RcsCi) Branch() (string, error) {
+	b, ok := v.Provider.Value("branch")
+	return layeredValue(v.Precedence, b, ok, v.Rcs.Branch)
+}
+
+func (v RcsCi) CommitCounter() (string, error) {
+	c, ok := v.Provider.Value("commit-counter")
+	return layeredValue(v.Precedence, c, ok, v.Rcs.CommitCounter)
+}
+
+func (v RcsCi) RepoCounter() (string, error) {
+	return v.Rcs.RepoCounter()
+}
+
+func (v RcsCi) RepoRoot() (string, error) {
+	return v.Rcs.RepoRoot()
+}
+
+var commitHashPtrn = regexp.MustCompile("^[0-9a-fA-F]{7,}$")
+
+// envCommitHash ignores environment values that are not commit hashes,
+// so that commit-hash is always a hash whatever a provider puts there.
+func (v RcsCi) envCommitHash() (string, bool) {
+	c, ok := v.Provider.Value("commit-hash")
+	if !ok || !commitHashPtrn.MatchString(c) {
+		return "", false
+	}
+	return c, true
+}
+
+func (v RcsCi) CommitHash() (string, error) {
+	c, ok := v.envCommitHash()
+	return layeredValue(v.Precedence, c, ok, v.Rcs.CommitHash)
+}
+
+func (v RcsCi) CommitHashShort() (string, error) {
+	c, ok := v.envCommitHash()
+	return layeredValue(v.Precedence, shortHash(c), ok, v.Rcs.CommitHashShort)
+}
+
+// shortHash abbreviates a full commit hash from the environment the way
+// git does by default.
+func shortHash(c string) string {
+	if len(c) < 7 {
+		return c
+	}
+	return c[0:7]
+}
+
+func (v RcsCi) LastTag() (string, error) {
+	return v.Rcs.LastTag()
+}
+
+func (v RcsCi) TagDistance() (string, error) {
+	return v.Rcs.TagDistance()
+}
+
+func (v RcsCi) ExactTag() (string, error) {
+	t, ok := v.Provider.Value("exact-tag")
+	return layeredValue(v.Precedence, t, ok, v.Rcs.ExactTag)
+}
+
+func (v RcsCi) Dirty() (bool, error) {
+	return v.Rcs.Dirty()
+}
+
+func (v RcsCi) CommitTime() (time.Time, error) {
+	return v.Rcs.CommitTime()
+}
+
+func (v RcsCi) BuildNumber() (string, error) {
+	n, ok := v.Provider.Value("build-number")
+	if !ok {
+		return "", fmt.Errorf("cannot find %s build number in environment", v.Provider.Name)
+	}
+	return n, nil
+}
+
+// PrNumber is empty when the build is not for a pull request.
+func (v RcsCi) PrNumber() (string, error) {
+	n, _ := v.Provider.Value("pr-number")
+	return n, nil
+}
+
+// PrSourceBranch is empty when the build is not for a pull request.
+// Providers that only report the branch being built report the source
+// branch there.
+func (v RcsCi) PrSourceBranch() (string, error) {
+	n, _ := v.PrNumber()
+	if n == "" {
+		return "", nil
+	}
+	b, ok := v.Provider.Value("pr-source-branch")
+	if ok {
+		return b, nil
+	}
+	b, _ = v.Provider.Value("branch")
+	return b, nil
+}
+
+// PrTargetBranch is empty when the build is not for a pull request or
+// the provider does not report the target.
+func (v RcsCi) PrTargetBranch() (string, error) {
+	n, _ := v.PrNumber()
+	if n == "" {
+		return "", nil
+	}
+	b, _ := v.Provider.Value("pr-target-branch")
+	return b, nil
+}
@@ -0,0 +1,592 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// clearCiEnv removes every variable that CI detection reads so that tests
+// behave the same on a developer machine and under CI.
+func clearCiEnv(t *testing.T) {
+	vars := []string{}
+	for _, p := range CiProviders {
+		vars = append(vars, p.Detect.Var)
+		for _, srcs := range p.Sources {
+			for _, s := range srcs {
+				vars = append(vars, s.Var)
+			}
+		}
+	}
+	for _, v := range vars {
+		t.Setenv(v, "")
+		os.Unsetenv(v)
+	}
+}
+
+func TestCiProviders(t *testing.T) {
+	var cases = []struct {
+		Env      map[string]string
+		Provider string
+		Branch   string
+		Hash     string
+		Tag      string
+		Build    string
+		Pr       string
+	}{
+		{
+			map[string]string{
+				"TRAVIS_BRANCH":       "master",
+				"TRAVIS_COMMIT":       "5555555555555555",
+				"TRAVIS_BUILD_NUMBER": "8",
+				"TRAVIS_PULL_REQUEST": "false",
+			},
+			"travis", "master", "5555555555555555", "", "8", "",
+		},
+		{
+			map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_REF":        "refs/heads/main",
+				"GITHUB_SHA":        "0123456789abcdef",
+				"GITHUB_RUN_NUMBER": "12",
+			},
+			"github", "main", "0123456789abcdef", "", "12", "",
+		},
+		{
+			map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_REF":        "refs/pull/42/merge",
+				"GITHUB_HEAD_REF":   "feature",
+				"GITHUB_SHA":        "0123456789abcdef",
+				"GITHUB_RUN_NUMBER": "13",
+			},
+			"github", "feature", "0123456789abcdef", "", "13", "42",
+		},
+		{
+			map[string]string{
+				"GITHUB_ACTIONS":    "true",
+				"GITHUB_REF":        "refs/tags/v1.2.3",
+				"GITHUB_SHA":        "0123456789abcdef",
+				"GITHUB_RUN_NUMBER": "14",
+			},
+			"github", "", "0123456789abcdef", "v1.2.3", "14", "",
+		},
+		{
+			map[string]string{
+				"GITLAB_CI":                           "true",
+				"CI_COMMIT_SHA":                       "abcdef0123456789",
+				"CI_PIPELINE_IID":                     "7",
+				"CI_MERGE_REQUEST_IID":                "3",
+				"CI_MERGE_REQUEST_SOURCE_BRANCH_NAME": "fix",
+			},
+			"gitlab", "fix", "abcdef0123456789", "", "7", "3",
+		},
+		{
+			map[string]string{
+				"JENKINS_URL":  "https://jenkins.example.com/",
+				"GIT_BRANCH":   "origin/develop",
+				"GIT_COMMIT":   "fedcba9876543210",
+				"BUILD_NUMBER": "99",
+			},
+			"jenkins", "develop", "fedcba9876543210", "", "99", "",
+		},
+		{
+			map[string]string{
+				"JENKINS_URL":   "https://jenkins.example.com/",
+				"BRANCH_NAME":   "PR-5",
+				"CHANGE_BRANCH": "topic",
+				"CHANGE_ID":     "5",
+				"GIT_COMMIT":    "fedcba9876543210",
+				"BUILD_NUMBER":  "100",
+			},
+			"jenkins", "topic", "fedcba9876543210", "", "100", "5",
+		},
+		{
+			map[string]string{
+				"CIRCLECI":            "true",
+				"CIRCLE_BRANCH":       "pull/8",
+				"CIRCLE_SHA1":         "1111111111111111",
+				"CIRCLE_BUILD_NUM":    "31",
+				"CIRCLE_PULL_REQUEST": "https://github.com/o/r/pull/8",
+			},
+			"circleci", "pull/8", "1111111111111111", "", "31", "8",
+		},
+		{
+			map[string]string{
+				"BUILDKITE":              "true",
+				"BUILDKITE_BRANCH":       "main",
+				"BUILDKITE_COMMIT":       "2222222222222222",
+				"BUILDKITE_BUILD_NUMBER": "5",
+				"BUILDKITE_PULL_REQUEST": "false",
+			},
+			"buildkite", "main", "2222222222222222", "", "5", "",
+		},
+		{
+			map[string]string{
+				"TF_BUILD":                             "True",
+				"BUILD_SOURCEBRANCH":                   "refs/pull/9/merge",
+				"SYSTEM_PULLREQUEST_SOURCEBRANCH":      "refs/heads/topic",
+				"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER": "9",
+				"BUILD_SOURCEVERSION":                  "3333333333333333",
+				"BUILD_BUILDID":                        "1234",
+			},
+			"azure", "topic", "3333333333333333", "", "1234", "9",
+		},
+		{
+			map[string]string{
+				"BITBUCKET_BUILD_NUMBER": "17",
+				"BITBUCKET_BRANCH":       "main",
+				"BITBUCKET_COMMIT":       "4444444444444444",
+				"BITBUCKET_TAG":          "v2.0.0",
+			},
+			"bitbucket", "main", "4444444444444444", "v2.0.0", "17", "",
+		},
+	}
+	for _, tc := range cases {
+		clearCiEnv(t)
+		for k, v := range tc.Env {
+			t.Setenv(k, v)
+		}
+		p, ok := DetectCiProvider(nil)
+		failWhen(t, !ok)
+		failWhen(t, p.Name != tc.Provider)
+		b, _ := p.Value("branch")
+		failWhen(t, b != tc.Branch)
+		ci := RcsCi{Provider: p, Rcs: RcsMissing{Err: errors.New("no repository")}}
+		h, err := ci.CommitHash()
+		failWhenErr(t, err)
+		failWhen(t, h != tc.Hash)
+		s, err := ci.CommitHashShort()
+		failWhenErr(t, err)
+		failWhen(t, s != tc.Hash[0:7])
+		tag, _ := p.Value("exact-tag")
+		failWhen(t, tag != tc.Tag)
+		n, err := ci.BuildNumber()
+		failWhenErr(t, err)
+		failWhen(t, n != tc.Build)
+		pr, err := ci.PrNumber()
+		failWhenErr(t, err)
+		if pr != tc.Pr {
+			t.Fatalf("%s: wanted pr-number '%s' but got '%s'", tc.Provider, tc.Pr, pr)
+		}
+	}
+}
+
+func TestNoCiProvider(t *testing.T) {
+	clearCiEnv(t)
+	_, ok := DetectCiProvider(nil)
+	failWhen(t, ok)
+}
+
+func TestCiProviderWrapsRepository(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo, err := ioutil.TempDir("", "vers-ci")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "init", "-q")
+	writeVersionFile(t, repo, "{branch}.{commit-counter}.{build-number}")
+	runInDir(t, repo, "git", "add", "version.json")
+	gitCommit(t, repo, "add version file")
+	gitCommit(t, repo, "second commit")
+
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("CI_COMMIT_BRANCH", "release")
+	t.Setenv("CI_PIPELINE_IID", "77")
+	ctx, err := NewBranchContext(filepath.Join(repo, "version.json"), "", []Option{})
+	failWhenErr(t, err)
+	version, err := ExpandVersion(ctx)
+	failWhenErr(t, err)
+	if version != "release.2.77" {
+		t.Fatalf("wanted 'release.2.77' but got '%s'", version)
+	}
+	provider, err := LookupParameter("ci-provider", ctx)
+	failWhenErr(t, err)
+	failWhen(t, provider != "gitlab")
+	pr, err := LookupParameter("pr-number", ctx)
+	failWhenErr(t, err)
+	failWhen(t, pr != "")
+}
+
+func TestCiParametersOutsideCi(t *testing.T) {
+	clearCiEnv(t)
+	ctx := Context{State: map[string]string{}, Rcs: RcsHg{Root: "."}}
+	provider, err := LookupParameter("ci-provider", &ctx)
+	failWhenErr(t, err)
+	failWhen(t, provider != "")
+	_, err = LookupParameter("build-number", &ctx)
+	failWhen(t, err == nil)
+}
+
+// ciTestRepo creates a git repository with two commits on branch feature.
+func ciTestRepo(t *testing.T, template string) string {
+	repo, err := ioutil.TempDir("", "vers-ci")
+	failWhenErr(t, err)
+	runInDir(t, repo, "git", "init", "-q")
+	runInDir(t, repo, "git", "checkout", "-q", "-b", "feature")
+	writeVersionFile(t, repo, template)
+	runInDir(t, repo, "git", "add", "version.json")
+	gitCommit(t, repo, "add version file")
+	gitCommit(t, repo, "second commit")
+	return repo
+}
+
+func TestDefaultsForUnavailableParameters(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "{branch}")
+	defer os.RemoveAll(repo)
+	var cases = []struct {
+		Template string
+		Travis   bool
+		Want     string
+	}{
+		{"{build-number:-local}", false, "local"},
+		{"b{build-number?}", false, "b"},
+		{"{build-number|default:0}", false, "0"},
+		{"{build-number:-local}", true, "local"},
+		{"{last-tag:-0.0.0}", false, "0.0.0"},
+		{"{tag-distance|default:0}", false, "0"},
+		{"{tag-major:-0}.{tag-minor:-1}", false, "0.1"},
+	}
+	for _, tc := range cases {
+		if tc.Travis {
+			t.Setenv("TRAVIS_BRANCH", "master")
+		} else {
+			os.Unsetenv("TRAVIS_BRANCH")
+		}
+		writeVersionFile(t, repo, tc.Template)
+		ctx, err := NewBranchContext(filepath.Join(repo, "version.json"), "", []Option{})
+		failWhenErr(t, err)
+		version, err := ExpandVersion(ctx)
+		failWhenErr(t, err)
+		if version != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Template, tc.Want, version)
+		}
+	}
+	// Without a default the lack of a value is still an error.
+	writeVersionFile(t, repo, "{last-tag}")
+	ctx, err := NewBranchContext(filepath.Join(repo, "version.json"), "", []Option{})
+	failWhenErr(t, err)
+	_, err = ExpandVersion(ctx)
+	failWhen(t, err == nil)
+}
+
+func TestTravisWrapsRepository(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "{branch}.{commit-counter}")
+	defer os.RemoveAll(repo)
+
+	t.Setenv("TRAVIS_BRANCH", "master")
+	ctx, err := NewBranchContext(filepath.Join(repo, "version.json"), "", []Option{})
+	failWhenErr(t, err)
+	version, err := ExpandVersion(ctx)
+	failWhenErr(t, err)
+	if version != "master.2" {
+		t.Fatalf("wanted 'master.2' but got '%s'", version)
+	}
+}
+
+func TestCiPrecedence(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	var cases = []struct {
+		Precedence string
+		Want       string
+	}{
+		{"", "release"},
+		{CiPrecedenceEnvironment, "release"},
+		{CiPrecedenceRepository, "feature"},
+		{CiPrecedenceIgnore, "feature"},
+	}
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "{branch}")
+	defer os.RemoveAll(repo)
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("CI_COMMIT_BRANCH", "release")
+	for _, tc := range cases {
+		rcs, err := GetRcs(filepath.Join(repo, "version.json"), &Config{CiPrecedence: tc.Precedence})
+		failWhenErr(t, err)
+		b, err := rcs.Branch()
+		failWhenErr(t, err)
+		if b != tc.Want {
+			t.Fatalf("precedence '%s': wanted '%s' but got '%s'", tc.Precedence, tc.Want, b)
+		}
+	}
+}
+
+func TestRepositoryPrecedenceSkipsDetachedHead(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "{branch}")
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "checkout", "-q", "--detach")
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("CI_COMMIT_BRANCH", "release")
+	rcs, err := GetRcs(filepath.Join(repo, "version.json"), &Config{CiPrecedence: CiPrecedenceRepository})
+	failWhenErr(t, err)
+	b, err := rcs.Branch()
+	failWhenErr(t, err)
+	failWhen(t, b != "release")
+}
+
+func TestCiWithoutRepository(t *testing.T) {
+	clearCiEnv(t)
+	dir, err := ioutil.TempDir("", "vers-ci")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	t.Setenv("BUILDKITE", "true")
+	t.Setenv("BUILDKITE_BRANCH", "main")
+	rcs, err := GetRcs(filepath.Join(dir, "version.json"), &Config{})
+	failWhenErr(t, err)
+	b, err := rcs.Branch()
+	failWhenErr(t, err)
+	failWhen(t, b != "main")
+	_, err = rcs.CommitCounter()
+	failWhen(t, err == nil)
+}
+
+func TestUnknownCiPrecedence(t *testing.T) {
+	tf, err := ioutil.TempFile("", "vers-config")
+	failWhenErr(t, err)
+	defer os.Remove(tf.Name())
+	c := Config{
+		Branches:     []BranchConfig{{BranchPattern: ".*", VersionTemplate: "1.0"}},
+		CiPrecedence: "sometimes",
+	}
+	failWhenErr(t, c.writeConfig(tf.Name()))
+	_, err = readConfig(tf.Name())
+	failWhen(t, err == nil)
+}
+
+func TestCustomEnvironment(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "{branch}.{commit-counter}.{build-number}")
+	defer os.RemoveAll(repo)
+	vf := filepath.Join(repo, "version.json")
+	c, err := readConfig(vf)
+	failWhenErr(t, err)
+	c.Environment = []EnvironmentConfig{{
+		Name:   "farm",
+		Detect: EnvSourceConfig{Var: "FARM_JOB"},
+		Parameters: map[string][]EnvSourceConfig{
+			"branch":         {{Var: "FARM_BRANCH"}},
+			"commit-hash":    {{Var: "FARM_REV"}},
+			"commit-counter": {{Var: "FARM_REV_COUNT"}},
+			"build-number":   {{Var: "FARM_JOB", Pattern: "-([0-9]+)$"}},
+		},
+	}}
+	failWhenErr(t, c.writeConfig(vf))
+
+	t.Setenv("FARM_JOB", "nightly-314")
+	t.Setenv("FARM_BRANCH", "stable")
+	t.Setenv("FARM_REV", "6666666666666666")
+	// Jenkins is detected too, but custom environments come first.
+	t.Setenv("JENKINS_URL", "https://jenkins.example.com/")
+	ctx, err := NewBranchContext(vf, "", []Option{})
+	failWhenErr(t, err)
+	version, err := ExpandVersion(ctx)
+	failWhenErr(t, err)
+	// FARM_REV_COUNT is unset, so the counter comes from the repository.
+	if version != "stable.2.314" {
+		t.Fatalf("wanted 'stable.2.314' but got '%s'", version)
+	}
+	provider, err := LookupParameter("ci-provider", ctx)
+	failWhenErr(t, err)
+	failWhen(t, provider != "farm")
+	hash, err := LookupParameter("commit-hash-short", ctx)
+	failWhenErr(t, err)
+	failWhen(t, hash != "6666666")
+
+	t.Setenv("FARM_REV_COUNT", "900")
+	ctx, err = NewBranchContext(vf, "", []Option{})
+	failWhenErr(t, err)
+	version, err = ExpandVersion(ctx)
+	failWhenErr(t, err)
+	failWhen(t, version != "stable.900.314")
+}
+
+func TestInvalidEnvironmentConfig(t *testing.T) {
+	var cases = []EnvironmentConfig{
+		{Detect: EnvSourceConfig{Var: "FARM_JOB"}},
+		{Name: "farm"},
+		{
+			Name:       "farm",
+			Detect:     EnvSourceConfig{Var: "FARM_JOB"},
+			Parameters: map[string][]EnvSourceConfig{"major": {{Var: "FARM_MAJOR"}}},
+		},
+		{
+			Name:       "farm",
+			Detect:     EnvSourceConfig{Var: "FARM_JOB"},
+			Parameters: map[string][]EnvSourceConfig{"branch": {{Var: "FARM_REF", Pattern: "^refs/heads/.+$"}}},
+		},
+		{
+			Name:       "farm",
+			Detect:     EnvSourceConfig{Var: "FARM_JOB", Pattern: "("},
+			Parameters: map[string][]EnvSourceConfig{},
+		},
+	}
+	for _, ec := range cases {
+		c := Config{Environment: []EnvironmentConfig{ec}}
+		_, err := c.CiProviders()
+		failWhen(t, err == nil)
+	}
+}
+
+func TestPrParameters(t *testing.T) {
+	var cases = []struct {
+		Env    map[string]string
+		Pr     string
+		Source string
+		Target string
+	}{
+		{
+			map[string]string{
+				"GITHUB_ACTIONS":  "true",
+				"GITHUB_REF":      "refs/pull/42/merge",
+				"GITHUB_HEAD_REF": "feature",
+				"GITHUB_BASE_REF": "main",
+			},
+			"42", "feature", "main",
+		},
+		{
+			map[string]string{
+				"GITHUB_ACTIONS": "true",
+				"GITHUB_REF":     "refs/heads/main",
+			},
+			"", "", "",
+		},
+		{
+			map[string]string{
+				"TRAVIS_BRANCH":              "master",
+				"TRAVIS_PULL_REQUEST":        "12",
+				"TRAVIS_PULL_REQUEST_BRANCH": "topic",
+			},
+			"12", "topic", "master",
+		},
+		{
+			map[string]string{
+				"TRAVIS_BRANCH":       "master",
+				"TRAVIS_PULL_REQUEST": "false",
+			},
+			"", "", "",
+		},
+		{
+			map[string]string{
+				"BUILDKITE":                          "true",
+				"BUILDKITE_BRANCH":                   "topic",
+				"BUILDKITE_PULL_REQUEST":             "3",
+				"BUILDKITE_PULL_REQUEST_BASE_BRANCH": "main",
+			},
+			"3", "topic", "main",
+		},
+		{
+			map[string]string{
+				"CIRCLECI":            "true",
+				"CIRCLE_BRANCH":       "topic",
+				"CIRCLE_PULL_REQUEST": "https://github.com/o/r/pull/8",
+			},
+			"8", "topic", "",
+		},
+		{
+			map[string]string{
+				"TF_BUILD":                             "True",
+				"SYSTEM_PULLREQUEST_PULLREQUESTNUMBER": "9",
+				"SYSTEM_PULLREQUEST_SOURCEBRANCH":      "refs/heads/topic",
+				"SYSTEM_PULLREQUEST_TARGETBRANCH":      "refs/heads/main",
+			},
+			"9", "topic", "main",
+		},
+	}
+	for _, tc := range cases {
+		clearCiEnv(t)
+		for k, v := range tc.Env {
+			t.Setenv(k, v)
+		}
+		p, ok := DetectCiProvider(nil)
+		failWhen(t, !ok)
+		ci := RcsCi{Provider: p, Rcs: RcsMissing{Err: errors.New("no repository")}}
+		pr, err := ci.PrNumber()
+		failWhenErr(t, err)
+		source, err := ci.PrSourceBranch()
+		failWhenErr(t, err)
+		target, err := ci.PrTargetBranch()
+		failWhenErr(t, err)
+		if pr != tc.Pr || source != tc.Source || target != tc.Target {
+			t.Fatalf("%s: wanted '%s' '%s' '%s' but got '%s' '%s' '%s'",
+				p.Name, tc.Pr, tc.Source, tc.Target, pr, source, target)
+		}
+	}
+}
+
+func TestCommitHashIsAlwaysAHash(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "{commit-hash}")
+	defer os.RemoveAll(repo)
+	rcs, err := GetRcs(filepath.Join(repo, "version.json"), &Config{})
+	failWhenErr(t, err)
+	want, err := rcs.CommitHash()
+	failWhenErr(t, err)
+
+	// Travis builds of pull requests used to report the pull request
+	// number as the hash.
+	t.Setenv("TRAVIS_BRANCH", "master")
+	t.Setenv("TRAVIS_PULL_REQUEST", "12")
+	t.Setenv("TRAVIS_PULL_REQUEST_NUMBER", "12")
+	t.Setenv("TRAVIS_COMMIT", "0123456789abcdef0123456789abcdef01234567")
+	rcs, err = GetRcs(filepath.Join(repo, "version.json"), &Config{})
+	failWhenErr(t, err)
+	hash, err := rcs.CommitHash()
+	failWhenErr(t, err)
+	failWhen(t, hash != "0123456789abcdef0123456789abcdef01234567")
+
+	// Values that are not hashes fall back to the repository.
+	t.Setenv("TRAVIS_COMMIT", "12")
+	rcs, err = GetRcs(filepath.Join(repo, "version.json"), &Config{})
+	failWhenErr(t, err)
+	hash, err = rcs.CommitHash()
+	failWhenErr(t, err)
+	failWhen(t, hash != want)
+	short, err := rcs.CommitHashShort()
+	failWhenErr(t, err)
+	failWhen(t, short != want[0:7])
+}
+
+func TestPullRequestVersion(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo := ciTestRepo(t, "")
+	defer os.RemoveAll(repo)
+	vf := filepath.Join(repo, "version.json")
+	c := Config{
+		Data: map[string]interface{}{"major": 1, "minor": 2, "release": 3},
+		Branches: []BranchConfig{
+			{
+				BranchPattern:   "^main$",
+				VersionTemplate: "{major}.{minor}.{release}",
+			},
+			{
+				BranchPattern:   ".*",
+				VersionTemplate: "{major}.{minor}.{release}-pr{pr-number}.{commit-counter}",
+			},
+		},
+		DataFileFields: []string{},
+	}
+	failWhenErr(t, c.writeConfig(vf))
+
+	t.Setenv("GITLAB_CI", "true")
+	t.Setenv("CI_MERGE_REQUEST_IID", "17")
+	t.Setenv("CI_MERGE_REQUEST_SOURCE_BRANCH_NAME", "topic")
+	t.Setenv("CI_MERGE_REQUEST_TARGET_BRANCH_NAME", "main")
+	ctx, err := NewBranchContext(vf, "", []Option{})
+	failWhenErr(t, err)
+	version, err := ExpandVersion(ctx)
+	failWhenErr(t, err)
+	if version != "1.2.3-pr17.2" {
+		t.Fatalf("wanted '1.2.3-pr17.2' but got '%s'", version)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RcsFossil struct {
+	Root string
+}
+
+func (v RcsFossil) Name() string {
+	return "fossil"
+}
+
+func (v RcsFossil) Branch() (string, error) {
+	out, err := RunRcsCommand(v.Root, "fossil", "branch", "current")
+	if err != nil {
+		return "", err
+	}
+	branch := strings.TrimSpace(out)
+	if branch == "" {
+		return "", errors.New("could not find branch in fossil output")
+	}
+	return branch, nil
+}
+
+func (v RcsFossil) CommitCounter() (string, error) {
+	out, err := RunRcsCommand(v.Root, "fossil", "timeline", "ancestors", "current",
+		"-t", "ci", "-n", "0", "-F", "%H")
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(CountFossilTimeline(out)), nil
+}
+
+func (v RcsFossil) RepoCounter() (string, error) {
+	info, err := v.FossilInfo()
+	if err != nil {
+		return "", err
+	}
+	return info.CheckIns, nil
+}
+
+func (v RcsFossil) RepoRoot() (string, error) {
+	info, err := v.FossilInfo()
+	if err != nil {
+		return "", err
+	}
+	return info.Repository, nil
+}
+
+func (v RcsFossil) CommitHash() (string, error) {
+	info, err := v.FossilInfo()
+	if err != nil {
+		return "", err
+	}
+	return info.Hash, nil
+}
+
+func (v RcsFossil) CommitHashShort() (string, error) {
+	info, err := v.FossilInfo()
+	if err != nil {
+		return "", err
+	}
+	// Fossil abbreviates hashes to ten characters.
+	return info.Hash[0:10], nil
+}
+
+func (v RcsFossil) LastTag() (string, error) {
+	tag, _, err := v.Describe()
+	return tag, err
+}
+
+func (v RcsFossil) TagDistance() (string, error) {
+	_, distance, err := v.Describe()
+	return distance, err
+}
+
+func (v RcsFossil) ExactTag() (string, error) {
+	tag, distance, err := v.Describe()
+	if err == errNoFossilTag || (err == nil && distance != "0") {
+		return "", nil
+	}
+	return tag, err
+}
+
+func (v RcsFossil) Dirty() (bool, error) {
+	out, err := RunRcsCommand(v.Root, "fossil", "changes")
+	if err != nil {
+		return false, err
+	}
+	// fossil changes lists edited, added, deleted, and missing files,
+	// so any output at all means the checkout is dirty.
+	return strings.TrimSpace(out) != "", nil
+}
+
+func (v RcsFossil) CommitTime() (time.Time, error) {
+	info, err := v.FossilInfo()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.Date, nil
+}
+
+var errNoFossilTag = NoTagError{Reason: "no tag reachable from current check-in"}
+
+func (v RcsFossil) Describe() (string, string, error) {
+	out, err := RunRcsCommand(v.Root, "fossil", "describe", "--long", "--digits", "40")
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", "", errNoFossilTag
+		}
+		return "", "", err
+	}
+	return ParseFossilDescribe(out)
+}
+
+func (v RcsFossil) FossilInfo() (FossilInfo, error) {
+	out, err := RunRcsCommand(v.Root, "fossil", "info")
+	if err != nil {
+		return FossilInfo{}, err
+	}
+	return ParseFossilInfo(out)
+}
+
+type FossilInfo struct {
+	Hash       string
+	Date       time.Time
+	Repository string
+	CheckIns   string
+}
+
+var fossilCheckoutPtrn = regexp.MustCompile("^([0-9a-f]{40,64}) ([0-9]{4}-[0-9]{2}-[0-9]{2} [0-9]{2}:[0-9]{2}:[0-9]{2}) UTC$")
+
+func ParseFossilInfo(fossilOut string) (FossilInfo, error) {
+	fields := map[string]string{}
+	for _, line := range strings.Split(fossilOut, "\n") {
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		// Only the first occurrence counts, as later ones describe
+		// parents and children.
+		k := strings.TrimSpace(parts[0])
+		if _, ok := fields[k]; !ok {
+			fields[k] = strings.TrimSpace(parts[1])
+		}
+	}
+	checkout, ok := fields["checkout"]
+	if !ok {
+		return FossilInfo{}, errors.New("could not find checkout in fossil output")
+	}
+	m := fossilCheckoutPtrn.FindStringSubmatch(checkout)
+	if m == nil {
+		return FossilInfo{}, fmt.Errorf("could not parse fossil checkout '%s'", checkout)
+	}
+	date, err := time.Parse("2006-01-02 15:04:05", m[2])
+	if err != nil {
+		return FossilInfo{}, errors.New("could not read checkout date")
+	}
+	checkIns, ok := fields["check-ins"]
+	if !ok {
+		return FossilInfo{}, errors.New("could not find check-ins in fossil output")
+	}
+	n, err := strconv.Atoi(checkIns)
+	if err != nil {
+		return FossilInfo{}, errors.New("could not read check-ins as number")
+	}
+	return FossilInfo{
+		Hash:       m[1],
+		Date:       date,
+		Repository: fields["repository"],
+		CheckIns:   strconv.Itoa(n),
+	}, nil
+}
+
+// ParseFossilDescribe reads describe's TAG-N-HASH output.  Unlike git,
+// fossil does not prefix the hash with g.
+func ParseFossilDescribe(describe string) (string, string, error) {
+	ptrn := regexp.MustCompile("^(.+)-(\\d+)-[0-9a-f]+$")
+	m := ptrn.FindStringSubmatch(strings.TrimSpace(describe))
+	if len(m) != 3 {
+		return "", "", fmt.Errorf("could not parse fossil describe output '%s'", strings.TrimSpace(describe))
+	}
+	return m[1], m[2], nil
+}
+
+var fossilHashLinePtrn = regexp.MustCompile("^[0-9a-f]{40,64}$")
+
+// CountFossilTimeline counts the check-ins in a timeline formatted as one
+// hash per line, skipping the date separators and trailers that fossil
+// adds around them.
+func CountFossilTimeline(fossilOut string) int {
+	n := 0
+	for _, line := range strings.Split(fossilOut, "\n") {
+		if fossilHashLinePtrn.MatchString(strings.TrimSpace(line)) {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseFossilInfo(t *testing.T) {
+	fossilOut := "project-name: vers\n" +
+		"repository:   /home/u/repos/vers.fossil\n" +
+		"local-root:   /home/u/src/vers/\n" +
+		"config-db:    /home/u/.fossil\n" +
+		"project-code: 1d2e3f4a5b6c7d8e9f0a1b2c3d4e5f6a7b8c9d0e\n" +
+		"checkout:     5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f 2017-07-14 02:40:00 UTC\n" +
+		"parent:       0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b 2017-07-13 10:00:00 UTC\n" +
+		"tags:         trunk, v1.4.2\n" +
+		"comment:      Fix: the parser (user: u)\n" +
+		"check-ins:    41\n"
+	info, err := ParseFossilInfo(fossilOut)
+	failWhenErr(t, err)
+	failWhen(t, info.Hash != "5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f")
+	failWhen(t, info.Date.Unix() != 1500000000)
+	failWhen(t, info.Repository != "/home/u/repos/vers.fossil")
+	failWhen(t, info.CheckIns != "41")
+}
+
+func TestParseFossilInfoSha3(t *testing.T) {
+	fossilOut := "checkout:     " +
+		"5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f5f2e6a1b9c8d7e6f5a4b3c2d " +
+		"2017-07-14 02:40:00 UTC\n" +
+		"check-ins:    7\n"
+	info, err := ParseFossilInfo(fossilOut)
+	failWhenErr(t, err)
+	failWhen(t, len(info.Hash) != 64)
+}
+
+func TestParseFossilInfoMalformed(t *testing.T) {
+	var cases = []string{
+		"",
+		"check-ins:    41\n",
+		"checkout:     5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f 2017-07-14 02:40:00 UTC\n",
+		"checkout:     5f2e6a1b 2017-07-14 02:40:00 UTC\ncheck-ins:    41\n",
+		"checkout:     5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f yesterday\ncheck-ins:    41\n",
+		"checkout:     5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f 2017-07-14 02:40:00 UTC\ncheck-ins:    many\n",
+	}
+	for _, tc := range cases {
+		_, err := ParseFossilInfo(tc)
+		failWhen(t, err == nil)
+	}
+}
+
+func TestParseFossilDescribe(t *testing.T) {
+	var cases = []struct {
+		Describe string
+		Tag      string
+		Distance string
+	}{
+		{"v1.4.2-0-5f2e6a1b9c\n", "v1.4.2", "0"},
+		{"release-2.0-12-5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f\n", "release-2.0", "12"},
+	}
+	for _, tc := range cases {
+		tag, distance, err := ParseFossilDescribe(tc.Describe)
+		failWhenErr(t, err)
+		failWhen(t, tag != tc.Tag)
+		failWhen(t, distance != tc.Distance)
+	}
+	_, _, err := ParseFossilDescribe("5f2e6a1b9c\n")
+	failWhen(t, err == nil)
+}
+
+func TestCountFossilTimeline(t *testing.T) {
+	fossilOut := "=== 2017-07-14 ===\n" +
+		"5f2e6a1b9c8d7e6f5a4b3c2d1e0f9a8b7c6d5e4f\n" +
+		"0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b\n" +
+		"=== 2017-07-13 ===\n" +
+		"1a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b\n" +
+		"+++ no more data (3) +++\n"
+	failWhen(t, CountFossilTimeline(fossilOut) != 3)
+	failWhen(t, CountFossilTimeline("") != 0)
+}
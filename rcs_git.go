@@ -1,11 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"errors"
+	"fmt"
 	"os/exec"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type RcsGit struct {
@@ -17,25 +19,31 @@ func (v RcsGit) Name() string {
 }
 
 func (v RcsGit) Branch() (string, error) {
-	cmd := exec.Command("git", "status", "--porcelain", "--branch")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	status, err := v.Status()
 	if err != nil {
 		return "", err
 	}
-	return ParseGitStatus(out.String())
+	return ParseGitStatus(status)
+}
+
+func (v RcsGit) Dirty() (bool, error) {
+	status, err := v.Status()
+	if err != nil {
+		return false, err
+	}
+	return ParseGitStatusDirty(status), nil
+}
+
+func (v RcsGit) Status() (string, error) {
+	return RunRcsCommand(v.Root, "git", "status", "--porcelain", "--branch")
 }
 
 func (v RcsGit) CommitCounter() (string, error) {
-	cmd := exec.Command("git", "rev-list", "HEAD", "--count")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := RunRcsCommand(v.Root, "git", "rev-list", "HEAD", "--count")
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(out.String(), "\n")
+	lines := strings.Split(out, "\n")
 	if len(lines) != 2 {
 		return "", errors.New("expected only one line from rev-list")
 	}
@@ -56,14 +64,11 @@ func (v RcsGit) RepoRoot() (string, error) {
 }
 
 func (v RcsGit) CommitHash() (string, error) {
-	cmd := exec.Command("git", "log", "-n", "1", "--pretty=format:%H")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := RunRcsCommand(v.Root, "git", "log", "-n", "1", "--pretty=format:%H")
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(out.String(), "\n")
+	lines := strings.Split(out, "\n")
 	if len(lines) != 1 {
 		return "", errors.New("expected only one line from git log")
 	}
@@ -71,20 +76,84 @@ func (v RcsGit) CommitHash() (string, error) {
 }
 
 func (v RcsGit) CommitHashShort() (string, error) {
-	cmd := exec.Command("git", "log", "-n", "1", "--pretty=format:%h")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := RunRcsCommand(v.Root, "git", "log", "-n", "1", "--pretty=format:%h")
 	if err != nil {
 		return "", err
 	}
-	lines := strings.Split(out.String(), "\n")
+	lines := strings.Split(out, "\n")
 	if len(lines) != 1 {
 		return "", errors.New("expected only one line from git log")
 	}
 	return lines[0], nil
 }
 
+func (v RcsGit) CommitTime() (time.Time, error) {
+	out, err := RunRcsCommand(v.Root, "git", "log", "-n", "1", "--pretty=format:%ct")
+	if err != nil {
+		return time.Time{}, err
+	}
+	secs, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("could not read commit time from git log")
+	}
+	return time.Unix(secs, 0), nil
+}
+
+func (v RcsGit) LastTag() (string, error) {
+	tag, _, err := v.Describe()
+	return tag, err
+}
+
+func (v RcsGit) TagDistance() (string, error) {
+	_, distance, err := v.Describe()
+	return distance, err
+}
+
+func (v RcsGit) ExactTag() (string, error) {
+	out, err := RunRcsCommand(v.Root, "git", "describe", "--exact-match", "HEAD")
+	if err != nil {
+		// Git signals an untagged HEAD by failing, and other failures
+		// are real errors.
+		if IsGitNoExactTag(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(out), nil
+}
+
+// IsGitNoExactTag recognizes describe --exact-match failing because HEAD
+// has no tag, or because the repository has no tags at all.
+func IsGitNoExactTag(err error) bool {
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok {
+		return false
+	}
+	stderr := string(exitErr.Stderr)
+	return strings.Contains(stderr, "no tag exactly matches") ||
+		strings.Contains(stderr, "No names found")
+}
+
+func (v RcsGit) Describe() (string, string, error) {
+	out, err := RunRcsCommand(v.Root, "git", "describe", "--long", "--abbrev=40", "HEAD")
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return "", "", NoTagError{Reason: "no annotated tag reachable from HEAD"}
+		}
+		return "", "", err
+	}
+	return ParseGitDescribe(out)
+}
+
+func ParseGitDescribe(describe string) (string, string, error) {
+	ptrn := regexp.MustCompile("^(.+)-(\\d+)-g[0-9a-f]+$")
+	m := ptrn.FindStringSubmatch(strings.TrimSpace(describe))
+	if len(m) != 3 {
+		return "", "", fmt.Errorf("could not parse git describe output '%s'", strings.TrimSpace(describe))
+	}
+	return m[1], m[2], nil
+}
+
 func ParseGitStatus(status string) (string, error) {
 	lines := strings.Split(status, "\n")
 	if len(lines) == 0 {
@@ -106,3 +175,15 @@ func ParseGitStatus(status string) (string, error) {
 	}
 	return strings.TrimPrefix(branch_line[1], "origin/"), nil
 }
+
+// Everything after the leading branch line describes a modified, staged,
+// or untracked file.
+func ParseGitStatusDirty(status string) bool {
+	lines := strings.Split(status, "\n")
+	for _, line := range lines[1:] {
+		if line != "" {
+			return true
+		}
+	}
+	return false
+}
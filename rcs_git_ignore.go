@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// GitIgnorePattern is one line of a .gitignore file.  Base is the
+// directory holding the file, relative to the top of the working tree,
+// and patterns only apply beneath it.
+type GitIgnorePattern struct {
+	Base     string
+	Negate   bool
+	DirOnly  bool
+	Anchored bool
+	Ptrn     *regexp.Regexp
+}
+
+// ParseGitIgnore reads patterns in the format shared by .gitignore,
+// info/exclude, and core.excludesFile.
+func ParseGitIgnore(base string, text string) []GitIgnorePattern {
+	patterns := []GitIgnorePattern{}
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSuffix(line, "\r")
+		// Trailing spaces are dropped unless escaped.
+		for strings.HasSuffix(line, " ") && !strings.HasSuffix(line, "\\ ") {
+			line = line[:len(line)-1]
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p := GitIgnorePattern{Base: base}
+		if strings.HasPrefix(line, "!") {
+			p.Negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			p.DirOnly = true
+			line = strings.TrimSuffix(line, "/")
+		}
+		// A slash anywhere but the end ties the pattern to Base.
+		if strings.Contains(line, "/") {
+			p.Anchored = true
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		ptrn, err := regexp.Compile("^" + gitGlobRegexp(line) + "$")
+		if err != nil {
+			// Git ignores patterns it cannot make sense of too.
+			continue
+		}
+		p.Ptrn = ptrn
+		patterns = append(patterns, p)
+	}
+	return patterns
+}
+
+// gitGlobRegexp translates a glob where * and ? never match a slash, and
+// ** matches any number of directories.
+func gitGlobRegexp(glob string) string {
+	var b strings.Builder
+	rs := []rune(glob)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		switch {
+		case r == '*' && i+1 < len(rs) && rs[i+1] == '*':
+			atStart := i == 0 || rs[i-1] == '/'
+			i++
+			if atStart && i+1 < len(rs) && rs[i+1] == '/' {
+				b.WriteString("(?:.*/)?")
+				i++
+			} else if atStart && i+1 == len(rs) {
+				b.WriteString(".*")
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case r == '*':
+			b.WriteString("[^/]*")
+		case r == '?':
+			b.WriteString("[^/]")
+		case r == '[':
+			end := i + 1
+			if end < len(rs) && (rs[end] == '!' || rs[end] == '^') {
+				end++
+			}
+			if end < len(rs) && rs[end] == ']' {
+				end++
+			}
+			for end < len(rs) && rs[end] != ']' {
+				end++
+			}
+			if end >= len(rs) {
+				b.WriteString(regexp.QuoteMeta(string(r)))
+				continue
+			}
+			class := string(rs[i+1 : end])
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.Replace(class, "\\", "\\\\", -1) + "]")
+			i = end
+		case r == '\\' && i+1 < len(rs):
+			i++
+			b.WriteString(regexp.QuoteMeta(string(rs[i])))
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	return b.String()
+}
+
+// IsGitIgnored applies patterns in order of increasing precedence, so the
+// last one to match decides.
+func IsGitIgnored(patterns []GitIgnorePattern, rel string, isDir bool) bool {
+	ignored := false
+	for _, p := range patterns {
+		if p.DirOnly && !isDir {
+			continue
+		}
+		sub := rel
+		if p.Base != "" {
+			if !strings.HasPrefix(rel, p.Base+"/") {
+				continue
+			}
+			sub = strings.TrimPrefix(rel, p.Base+"/")
+		}
+		if !p.Anchored {
+			sub = path.Base(sub)
+		}
+		if p.Ptrn.MatchString(sub) {
+			ignored = !p.Negate
+		}
+	}
+	return ignored
+}
+
+// ExcludePatterns reads the ignore rules that apply to the whole working
+// tree: the user's excludes file and the repository's info/exclude.
+func (r *GitRepo) ExcludePatterns() ([]GitIgnorePattern, error) {
+	patterns := []GitIgnorePattern{}
+	for _, fn := range []string{r.excludesFile(), filepath.Join(r.CommonDir, "info", "exclude")} {
+		if fn == "" {
+			continue
+		}
+		data, err := ioutil.ReadFile(fn)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, ParseGitIgnore("", string(data))...)
+	}
+	return patterns, nil
+}
+
+// excludesFile finds core.excludesFile in the repository's or the
+// user's config, or else git's default location for it.
+func (r *GitRepo) excludesFile() string {
+	home, _ := os.UserHomeDir()
+	for _, fn := range []string{filepath.Join(r.CommonDir, "config"), filepath.Join(home, ".gitconfig")} {
+		v := gitConfigValue(fn, "core", "excludesfile")
+		if strings.HasPrefix(v, "~/") && home != "" {
+			v = filepath.Join(home, v[2:])
+		}
+		if v != "" {
+			return v
+		}
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home != "" {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
+
+// gitConfigValue reads a simple key from a git config file.  Includes and
+// subsections are not followed.
+func gitConfigValue(fn string, section string, key string) string {
+	f, err := os.Open(fn)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	value := ""
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.ToLower(strings.TrimSpace(line[1 : len(line)-1]))
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if current != section || len(parts) != 2 {
+			continue
+		}
+		if strings.ToLower(strings.TrimSpace(parts[0])) == key {
+			value = strings.Trim(strings.TrimSpace(parts[1]), "\"")
+		}
+	}
+	return value
+}
+
+// HasUntracked reports whether the working tree holds a file that is
+// neither tracked nor ignored, which git status counts as a change.
+func (r *GitRepo) HasUntracked(root string, tracked map[string]bool) (bool, error) {
+	patterns, err := r.ExcludePatterns()
+	if err != nil {
+		return false, err
+	}
+	return hasUntracked(root, "", tracked, patterns)
+}
+
+func hasUntracked(root string, rel string, tracked map[string]bool, patterns []GitIgnorePattern) (bool, error) {
+	dir := filepath.Join(root, filepath.FromSlash(rel))
+	data, err := ioutil.ReadFile(filepath.Join(dir, ".gitignore"))
+	if err == nil {
+		patterns = append(append([]GitIgnorePattern{}, patterns...), ParseGitIgnore(rel, string(data))...)
+	} else if !os.IsNotExist(err) {
+		return false, err
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return false, err
+	}
+	for _, fi := range fis {
+		name := fi.Name()
+		if rel == "" && name == ".git" {
+			continue
+		}
+		path := name
+		if rel != "" {
+			path = rel + "/" + name
+		}
+		if tracked[path] {
+			continue
+		}
+		isDir := fi.IsDir()
+		if IsGitIgnored(patterns, path, isDir) {
+			continue
+		}
+		if !isDir {
+			return true, nil
+		}
+		if _, err := os.Lstat(filepath.Join(dir, name, ".git")); err == nil {
+			// A repository that isn't a submodule is untracked.
+			return true, nil
+		}
+		found, err := hasUntracked(root, path, tracked, patterns)
+		if err != nil || found {
+			return found, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	gitModeTree    = 0040000
+	gitModeFile    = 0100644
+	gitModeExec    = 0100755
+	gitModeSymlink = 0120000
+	gitModeGitlink = 0160000
+)
+
+// GitIndexEntry is a file staged in .git/index.
+type GitIndexEntry struct {
+	Path         string
+	Mode         uint32
+	Hash         string
+	Size         uint32
+	MtimeSec     uint32
+	MtimeNsec    uint32
+	Stage        int
+	SkipWorktree bool
+	IntentToAdd  bool
+}
+
+// ParseGitIndex reads versions 2 through 4 of the index format.  The
+// extensions that follow the entries only cache information, so they are
+// ignored.
+func ParseGitIndex(data []byte) ([]GitIndexEntry, error) {
+	if len(data) < 12 || string(data[0:4]) != "DIRC" {
+		return nil, errors.New("not a git index")
+	}
+	version := binary.BigEndian.Uint32(data[4:8])
+	if version < 2 || version > 4 {
+		return nil, fmt.Errorf("unsupported git index version %d", version)
+	}
+	count := int(binary.BigEndian.Uint32(data[8:12]))
+	entries := []GitIndexEntry{}
+	pos := 12
+	prev := ""
+	for i := 0; i < count; i++ {
+		start := pos
+		if len(data) < pos+62 {
+			return nil, errors.New("git index is truncated")
+		}
+		e := GitIndexEntry{
+			MtimeSec:  binary.BigEndian.Uint32(data[pos+8:]),
+			MtimeNsec: binary.BigEndian.Uint32(data[pos+12:]),
+			Mode:      binary.BigEndian.Uint32(data[pos+24:]),
+			Size:      binary.BigEndian.Uint32(data[pos+36:]),
+			Hash:      hex.EncodeToString(data[pos+40 : pos+60]),
+		}
+		flags := binary.BigEndian.Uint16(data[pos+60:])
+		e.Stage = int(flags>>12) & 3
+		pos += 62
+		if flags&0x4000 != 0 {
+			if version < 3 || len(data) < pos+2 {
+				return nil, errors.New("malformed git index entry")
+			}
+			extended := binary.BigEndian.Uint16(data[pos:])
+			e.SkipWorktree = extended&0x4000 != 0
+			e.IntentToAdd = extended&0x2000 != 0
+			pos += 2
+		}
+		if version == 4 {
+			// Paths are compressed against the previous entry's path.
+			rd := bufio.NewReader(bytes.NewReader(data[pos:]))
+			strip, err := readOfsDeltaDistance(rd)
+			if err != nil || strip > int64(len(prev)) {
+				return nil, errors.New("malformed git index path")
+			}
+			pos = len(data) - rd.Buffered()
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, errors.New("malformed git index path")
+			}
+			e.Path = prev[:len(prev)-int(strip)] + string(data[pos:pos+end])
+			pos += end + 1
+		} else {
+			end := bytes.IndexByte(data[pos:], 0)
+			if end < 0 {
+				return nil, errors.New("malformed git index path")
+			}
+			e.Path = string(data[pos : pos+end])
+			// Entries are padded with nuls to a multiple of eight bytes.
+			pos = start + (pos-start+end+8)&^7
+		}
+		prev = e.Path
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// GitTreeEntry is a blob, symlink, or submodule in a flattened tree.
+type GitTreeEntry struct {
+	Mode uint32
+	Hash string
+}
+
+// ReadTree flattens a tree into the paths of everything that isn't
+// itself a tree.
+func (r *GitRepo) ReadTree(hash string, prefix string, files map[string]GitTreeEntry) error {
+	kind, data, err := r.ReadObject(hash)
+	if err != nil {
+		return err
+	}
+	if kind != "tree" {
+		return fmt.Errorf("object %s is a %s, not a tree", hash, kind)
+	}
+	for len(data) > 0 {
+		sp := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if sp < 0 || nul < sp || len(data) < nul+21 {
+			return fmt.Errorf("malformed tree %s", hash)
+		}
+		var mode uint32
+		if _, err := fmt.Sscanf(string(data[:sp]), "%o", &mode); err != nil {
+			return fmt.Errorf("malformed tree %s", hash)
+		}
+		name := prefix + string(data[sp+1:nul])
+		entry := hex.EncodeToString(data[nul+1 : nul+21])
+		data = data[nul+21:]
+		if mode == gitModeTree {
+			if err := r.ReadTree(entry, name+"/", files); err != nil {
+				return err
+			}
+			continue
+		}
+		files[name] = GitTreeEntry{Mode: mode, Hash: entry}
+	}
+	return nil
+}
+
+// TreeOf returns the hash of a commit's tree.
+func (r *GitRepo) TreeOf(hash string) (string, error) {
+	kind, data, err := r.ReadObject(hash)
+	if err != nil {
+		return "", err
+	}
+	if kind != "commit" {
+		return "", fmt.Errorf("object %s is a %s, not a commit", hash, kind)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "tree ") {
+			return strings.TrimPrefix(line, "tree "), nil
+		}
+	}
+	return "", fmt.Errorf("commit %s has no tree", hash)
+}
+
+// Dirty reports whether the index differs from HEAD, the tracked files
+// in the working tree differ from the index, or there are untracked files
+// that aren't ignored, just as git status does.
+func (r *GitRepo) Dirty(root string) (bool, error) {
+	indexPath := filepath.Join(r.GitDir, "index")
+	data, err := ioutil.ReadFile(indexPath)
+	if os.IsNotExist(err) {
+		data = []byte("DIRC\x00\x00\x00\x02\x00\x00\x00\x00")
+	} else if err != nil {
+		return false, err
+	}
+	entries, err := ParseGitIndex(data)
+	if err != nil {
+		return false, err
+	}
+	head, err := r.ResolveHead()
+	if err != nil {
+		return false, err
+	}
+	tree, err := r.TreeOf(head)
+	if err != nil {
+		return false, err
+	}
+	files := map[string]GitTreeEntry{}
+	if err := r.ReadTree(tree, "", files); err != nil {
+		return false, err
+	}
+	if len(files) != len(entries) {
+		return true, nil
+	}
+	var indexTime os.FileInfo
+	if fi, err := os.Stat(indexPath); err == nil {
+		indexTime = fi
+	}
+	tracked := map[string]bool{}
+	for _, e := range entries {
+		tracked[e.Path] = true
+		if e.Mode == gitModeTree {
+			return false, errors.New("native git reader does not support sparse indexes")
+		}
+		if e.Stage != 0 || e.IntentToAdd {
+			return true, nil
+		}
+		if f, ok := files[e.Path]; !ok || f.Mode != e.Mode || f.Hash != e.Hash {
+			return true, nil
+		}
+		if e.SkipWorktree || e.Mode == gitModeGitlink {
+			continue
+		}
+		changed, err := worktreeChanged(root, e, indexTime)
+		if err != nil || changed {
+			return changed, err
+		}
+	}
+	return r.HasUntracked(root, tracked)
+}
+
+// worktreeChanged compares a working file with its index entry.  As in
+// git, an unchanged size and mtime mean the file is unchanged unless the
+// file was modified too close to when the index was written to be sure.
+func worktreeChanged(root string, e GitIndexEntry, index os.FileInfo) (bool, error) {
+	path := filepath.Join(root, filepath.FromSlash(e.Path))
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var content []byte
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		if e.Mode != gitModeSymlink {
+			return true, nil
+		}
+		target, err := os.Readlink(path)
+		if err != nil {
+			return false, err
+		}
+		content = []byte(filepath.ToSlash(target))
+	case fi.Mode().IsRegular():
+		exec := fi.Mode()&0100 != 0
+		if (e.Mode != gitModeFile && e.Mode != gitModeExec) || exec != (e.Mode == gitModeExec) {
+			return true, nil
+		}
+		if uint32(fi.Size()) != e.Size {
+			return true, nil
+		}
+		mtime := fi.ModTime()
+		racy := index == nil || !mtime.Before(index.ModTime())
+		if !racy && uint32(mtime.Unix()) == e.MtimeSec && uint32(mtime.Nanosecond()) == e.MtimeNsec {
+			return false, nil
+		}
+		content, err = ioutil.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+	default:
+		return true, nil
+	}
+	h := sha1.New()
+	fmt.Fprintf(h, "blob %d\x00", len(content))
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil)) != e.Hash, nil
+}
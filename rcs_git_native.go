@@ -0,0 +1,942 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RcsGitNative reads the .git directory directly so that git
+// repositories can be versioned on machines without the git binary.
+type RcsGitNative struct {
+	Root string
+	repo *gitRepoCell
+}
+
+// gitRepoCell holds a repository opened on first use, so that its caches
+// are shared by every lookup made through the same backend.
+type gitRepoCell struct {
+	repo *GitRepo
+	err  error
+	done bool
+}
+
+func NewRcsGitNative(root string) RcsGitNative {
+	return RcsGitNative{Root: root, repo: &gitRepoCell{}}
+}
+
+func (v RcsGitNative) Repo() (*GitRepo, error) {
+	if v.repo == nil {
+		return OpenGitRepo(v.Root)
+	}
+	if !v.repo.done {
+		v.repo.repo, v.repo.err = OpenGitRepo(v.Root)
+		v.repo.done = true
+	}
+	return v.repo.repo, v.repo.err
+}
+
+func (v RcsGitNative) Name() string {
+	return "git-native"
+}
+
+func (v RcsGitNative) Branch() (string, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return "", err
+	}
+	ref, err := repo.Head()
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(ref, "ref: ") {
+		// Detached head, which is what git status reports too.
+		return "HEAD", nil
+	}
+	return strings.TrimPrefix(strings.TrimPrefix(ref, "ref: "), "refs/heads/"), nil
+}
+
+func (v RcsGitNative) CommitCounter() (string, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.ResolveHead()
+	if err != nil {
+		return "", err
+	}
+	ancestors, err := repo.Ancestors(head)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(len(ancestors)), nil
+}
+
+func (v RcsGitNative) RepoCounter() (string, error) {
+	return "", errors.New("Git does not support whole-repo commit counters")
+}
+
+func (v RcsGitNative) RepoRoot() (string, error) {
+	return "", errors.New("Git does not support repo root")
+}
+
+func (v RcsGitNative) CommitHash() (string, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return "", err
+	}
+	return repo.ResolveHead()
+}
+
+func (v RcsGitNative) CommitHashShort() (string, error) {
+	h, err := v.CommitHash()
+	if err != nil {
+		return "", err
+	}
+	return h[0:7], nil
+}
+
+func (v RcsGitNative) CommitTime() (time.Time, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return time.Time{}, err
+	}
+	head, err := repo.ResolveHead()
+	if err != nil {
+		return time.Time{}, err
+	}
+	commit, err := repo.ReadCommit(head)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return ParseGitSignatureTime(commit.Committer)
+}
+
+func (v RcsGitNative) LastTag() (string, error) {
+	tag, _, err := v.Describe()
+	return tag, err
+}
+
+func (v RcsGitNative) TagDistance() (string, error) {
+	_, distance, err := v.Describe()
+	return distance, err
+}
+
+func (v RcsGitNative) ExactTag() (string, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return "", err
+	}
+	head, err := repo.ResolveHead()
+	if err != nil {
+		return "", err
+	}
+	tags, err := repo.AnnotatedTags()
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range tags {
+		if tag.Commit == head {
+			return tag.Name, nil
+		}
+	}
+	return "", nil
+}
+
+func (v RcsGitNative) Dirty() (bool, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return false, err
+	}
+	return repo.Dirty(v.Root)
+}
+
+// Describe mirrors git describe: the nearest annotated tag is the first
+// one found walking back from HEAD, and the distance counts the commits
+// reachable from HEAD but not from the tag.
+func (v RcsGitNative) Describe() (string, string, error) {
+	repo, err := v.Repo()
+	if err != nil {
+		return "", "", err
+	}
+	head, err := repo.ResolveHead()
+	if err != nil {
+		return "", "", err
+	}
+	tag, distance, err := repo.Describe(head)
+	if err != nil {
+		return "", "", err
+	}
+	return tag, strconv.Itoa(distance), nil
+}
+
+// GitRepo locates refs and objects.  Linked worktrees keep HEAD in their
+// own git dir but share refs and objects through the common dir.  Pack
+// indexes, commits, and ancestor sets are read once and then cached.
+type GitRepo struct {
+	GitDir    string
+	CommonDir string
+
+	packIndexes []GitPackIndex
+	indexesRead bool
+	commits     map[string]GitCommit
+	ancestors   map[string]map[string]bool
+	annotated   []GitTag
+	shallow     map[string]bool
+}
+
+func OpenGitRepo(root string) (*GitRepo, error) {
+	gitDir := filepath.Join(root, ".git")
+	fi, err := os.Stat(gitDir)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		// Worktrees and submodules use a .git file pointing at the real
+		// git dir.
+		data, err := ioutil.ReadFile(gitDir)
+		if err != nil {
+			return nil, err
+		}
+		line := strings.TrimSpace(string(data))
+		if !strings.HasPrefix(line, "gitdir: ") {
+			return nil, errors.New("malformed .git file")
+		}
+		gitDir = strings.TrimPrefix(line, "gitdir: ")
+		if !filepath.IsAbs(gitDir) {
+			gitDir = filepath.Join(root, gitDir)
+		}
+	}
+	repo := GitRepo{
+		GitDir:    gitDir,
+		CommonDir: gitDir,
+		commits:   map[string]GitCommit{},
+		ancestors: map[string]map[string]bool{},
+	}
+	data, err := ioutil.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err == nil {
+		commonDir := strings.TrimSpace(string(data))
+		if !filepath.IsAbs(commonDir) {
+			commonDir = filepath.Join(gitDir, commonDir)
+		}
+		repo.CommonDir = commonDir
+	}
+	return &repo, nil
+}
+
+func (r *GitRepo) Head() (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.GitDir, "HEAD"))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func (r *GitRepo) ResolveHead() (string, error) {
+	return r.ResolveRef("HEAD")
+}
+
+func (r *GitRepo) ResolveRef(name string) (string, error) {
+	// Symbolic refs may chain, but never legitimately very far.
+	for i := 0; i < 10; i++ {
+		value, err := r.readRef(name)
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(value, "ref: ") {
+			if !IsGitHash(value) {
+				return "", fmt.Errorf("malformed ref %s", name)
+			}
+			return value, nil
+		}
+		name = strings.TrimPrefix(value, "ref: ")
+	}
+	return "", fmt.Errorf("too many levels of symbolic refs resolving %s", name)
+}
+
+func (r *GitRepo) readRef(name string) (string, error) {
+	for _, dir := range []string{r.GitDir, r.CommonDir} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err == nil {
+			return strings.TrimSpace(string(data)), nil
+		}
+	}
+	refs, err := r.PackedRefs()
+	if err != nil {
+		return "", err
+	}
+	for _, ref := range refs {
+		if ref.Name == name {
+			return ref.Hash, nil
+		}
+	}
+	// An unborn branch has a symbolic HEAD but no ref.
+	return "", fmt.Errorf("ref %s does not exist", name)
+}
+
+type GitRef struct {
+	Name   string
+	Hash   string
+	Peeled string
+}
+
+func (r *GitRepo) PackedRefs() ([]GitRef, error) {
+	data, err := ioutil.ReadFile(filepath.Join(r.CommonDir, "packed-refs"))
+	if os.IsNotExist(err) {
+		return []GitRef{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParsePackedRefs(string(data))
+}
+
+func ParsePackedRefs(packed string) ([]GitRef, error) {
+	refs := []GitRef{}
+	for _, line := range strings.Split(packed, "\n") {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "^") {
+			// Peeled value of the preceding annotated tag.
+			if len(refs) == 0 {
+				return nil, errors.New("peeled packed-ref without a ref")
+			}
+			refs[len(refs)-1].Peeled = strings.TrimPrefix(line, "^")
+			continue
+		}
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 || !IsGitHash(parts[0]) {
+			return nil, errors.New("found unparsable packed-refs line")
+		}
+		refs = append(refs, GitRef{Name: parts[1], Hash: parts[0]})
+	}
+	return refs, nil
+}
+
+// Tags returns every tag ref, with loose refs shadowing packed ones.
+func (r *GitRepo) Tags() (map[string]GitRef, error) {
+	tags := map[string]GitRef{}
+	refs, err := r.PackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for _, ref := range refs {
+		if strings.HasPrefix(ref.Name, "refs/tags/") {
+			tags[strings.TrimPrefix(ref.Name, "refs/tags/")] = ref
+		}
+	}
+	tagDir := filepath.Join(r.CommonDir, "refs", "tags")
+	err = filepath.Walk(tagDir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(tagDir, path)
+		if err != nil {
+			return err
+		}
+		name = filepath.ToSlash(name)
+		tags[name] = GitRef{Name: "refs/tags/" + name, Hash: strings.TrimSpace(string(data))}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+type GitTag struct {
+	Name   string
+	Commit string
+}
+
+// AnnotatedTags returns tags pointing at tag objects, peeled to the
+// commits they describe and sorted by name.
+func (r *GitRepo) AnnotatedTags() ([]GitTag, error) {
+	if r.annotated != nil {
+		return r.annotated, nil
+	}
+	tags, err := r.Tags()
+	if err != nil {
+		return nil, err
+	}
+	annotated := []GitTag{}
+	for name, ref := range tags {
+		commit, ok, err := r.PeelTag(ref)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			annotated = append(annotated, GitTag{Name: name, Commit: commit})
+		}
+	}
+	sort.Slice(annotated, func(i, j int) bool { return annotated[i].Name < annotated[j].Name })
+	r.annotated = annotated
+	return annotated, nil
+}
+
+// PeelTag follows an annotated tag, and any tags it points at in turn,
+// to the commit it names.  Like git describe, it reports false for
+// lightweight tags and for tags of trees or blobs.
+func (r *GitRepo) PeelTag(ref GitRef) (string, bool, error) {
+	if ref.Peeled != "" {
+		// Packed refs record the peeled object of annotated tags.
+		kind, _, err := r.ReadObject(ref.Peeled)
+		if err != nil {
+			return "", false, err
+		}
+		return ref.Peeled, kind == "commit", nil
+	}
+	hash := ref.Hash
+	// Tags of tags may nest, but never legitimately very deeply.
+	for depth := 0; depth < 10; depth++ {
+		kind, data, err := r.ReadObject(hash)
+		if err != nil {
+			return "", false, err
+		}
+		if kind != "tag" {
+			return hash, depth > 0 && kind == "commit", nil
+		}
+		hash, _, err = ParseGitTagObject(data)
+		if err != nil {
+			return "", false, err
+		}
+	}
+	return "", false, fmt.Errorf("too many levels of tags resolving %s", ref.Name)
+}
+
+// Describe finds the nearest annotated tag with a breadth first walk
+// from hash that stops at the first tagged commit.  Several tags on that
+// commit resolve to the first by name.
+func (r *GitRepo) Describe(hash string) (string, int, error) {
+	tags, err := r.AnnotatedTags()
+	if err != nil {
+		return "", 0, err
+	}
+	tagged := map[string]string{}
+	for _, tag := range tags {
+		if _, ok := tagged[tag.Commit]; !ok {
+			tagged[tag.Commit] = tag.Name
+		}
+	}
+	seen := map[string]bool{hash: true}
+	pending := []string{hash}
+	for len(pending) > 0 {
+		h := pending[0]
+		pending = pending[1:]
+		if name, ok := tagged[h]; ok {
+			ancestors, err := r.Ancestors(hash)
+			if err != nil {
+				return "", 0, err
+			}
+			tagAncestors, err := r.Ancestors(h)
+			if err != nil {
+				return "", 0, err
+			}
+			return name, len(ancestors) - len(tagAncestors), nil
+		}
+		commit, err := r.ReadCommit(h)
+		if err != nil {
+			return "", 0, err
+		}
+		for _, p := range commit.Parents {
+			if !seen[p] {
+				seen[p] = true
+				pending = append(pending, p)
+			}
+		}
+	}
+	return "", 0, NoTagError{Reason: "no annotated tag reachable from HEAD"}
+}
+
+// Ancestors returns the set of commits reachable from hash, including
+// hash itself.
+func (r *GitRepo) Ancestors(hash string) (map[string]bool, error) {
+	if seen, ok := r.ancestors[hash]; ok {
+		return seen, nil
+	}
+	seen := map[string]bool{}
+	pending := []string{hash}
+	for len(pending) > 0 {
+		h := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		commit, err := r.ReadCommit(h)
+		if err != nil {
+			return nil, err
+		}
+		pending = append(pending, commit.Parents...)
+	}
+	r.ancestors[hash] = seen
+	return seen, nil
+}
+
+type GitCommit struct {
+	Parents   []string
+	Committer string
+}
+
+func (r *GitRepo) ReadCommit(hash string) (GitCommit, error) {
+	if commit, ok := r.commits[hash]; ok {
+		return commit, nil
+	}
+	kind, data, err := r.ReadObject(hash)
+	if err != nil {
+		return GitCommit{}, err
+	}
+	if kind != "commit" {
+		return GitCommit{}, fmt.Errorf("object %s is a %s, not a commit", hash, kind)
+	}
+	commit, err := ParseGitCommit(data)
+	if err != nil {
+		return GitCommit{}, err
+	}
+	shallow, err := r.Shallow()
+	if err != nil {
+		return GitCommit{}, err
+	}
+	if shallow[hash] {
+		// Shallow clones lack the parents of these commits, so like git
+		// they are treated as roots.
+		commit.Parents = []string{}
+	}
+	r.commits[hash] = commit
+	return commit, nil
+}
+
+func ParseGitCommit(data []byte) (GitCommit, error) {
+	commit := GitCommit{Parents: []string{}}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			// Headers end at the first blank line.
+			break
+		}
+		if strings.HasPrefix(line, "parent ") {
+			parent := strings.TrimPrefix(line, "parent ")
+			if !IsGitHash(parent) {
+				return commit, errors.New("malformed parent in commit")
+			}
+			commit.Parents = append(commit.Parents, parent)
+		} else if strings.HasPrefix(line, "committer ") {
+			commit.Committer = strings.TrimPrefix(line, "committer ")
+		}
+	}
+	return commit, nil
+}
+
+// ParseGitSignatureTime reads the timestamp from an author or committer
+// line such as "Name <email> 1500000000 +0100".
+func ParseGitSignatureTime(sig string) (time.Time, error) {
+	fields := strings.Fields(sig[strings.LastIndex(sig, ">")+1:])
+	if len(fields) != 2 {
+		return time.Time{}, errors.New("malformed signature in commit")
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, errors.New("malformed timestamp in commit")
+	}
+	return time.Unix(secs, 0), nil
+}
+
+// ParseGitTagObject returns the object a tag points at along with its
+// type.
+func ParseGitTagObject(data []byte) (string, string, error) {
+	object := ""
+	kind := ""
+	for _, line := range strings.Split(string(data), "\n") {
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "object ") {
+			object = strings.TrimPrefix(line, "object ")
+		} else if strings.HasPrefix(line, "type ") {
+			kind = strings.TrimPrefix(line, "type ")
+		}
+	}
+	if !IsGitHash(object) {
+		return "", "", errors.New("malformed object in tag")
+	}
+	return object, kind, nil
+}
+
+func IsGitHash(s string) bool {
+	if len(s) != 40 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// ReadObject returns the type and contents of an object, looking first
+// for a loose object and then through the pack files.
+func (r *GitRepo) ReadObject(hash string) (string, []byte, error) {
+	if !IsGitHash(hash) {
+		return "", nil, fmt.Errorf("malformed object id %s", hash)
+	}
+	objDir := filepath.Join(r.CommonDir, "objects")
+	f, err := os.Open(filepath.Join(objDir, hash[0:2], hash[2:]))
+	if err == nil {
+		defer f.Close()
+		return ReadLooseObject(f)
+	}
+	if !os.IsNotExist(err) {
+		return "", nil, err
+	}
+	idxs, err := r.PackIndexes()
+	if err != nil {
+		return "", nil, err
+	}
+	want, err := hex.DecodeString(hash)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, idx := range idxs {
+		offset, ok, err := idx.Find(want)
+		if err != nil {
+			return "", nil, err
+		}
+		if !ok {
+			continue
+		}
+		pack := GitPack{Repo: r, Path: strings.TrimSuffix(idx.Path, ".idx") + ".pack"}
+		return pack.ReadObjectAt(offset)
+	}
+	return "", nil, fmt.Errorf("object %s not found", hash)
+}
+
+// Shallow reads the commits at the boundary of a shallow clone.
+func (r *GitRepo) Shallow() (map[string]bool, error) {
+	if r.shallow != nil {
+		return r.shallow, nil
+	}
+	shallow := map[string]bool{}
+	data, err := ioutil.ReadFile(filepath.Join(r.CommonDir, "shallow"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" {
+			shallow[line] = true
+		}
+	}
+	r.shallow = shallow
+	return shallow, nil
+}
+
+// PackIndexes reads every pack index in the repository the first time
+// it is called.
+func (r *GitRepo) PackIndexes() ([]GitPackIndex, error) {
+	if r.indexesRead {
+		return r.packIndexes, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(r.CommonDir, "objects", "pack", "*.idx"))
+	if err != nil {
+		return nil, err
+	}
+	idxs := []GitPackIndex{}
+	for _, path := range paths {
+		idx, err := ReadGitPackIndex(path)
+		if err != nil {
+			return nil, err
+		}
+		idxs = append(idxs, idx)
+	}
+	r.packIndexes = idxs
+	r.indexesRead = true
+	return idxs, nil
+}
+
+func ReadLooseObject(rd io.Reader) (string, []byte, error) {
+	z, err := zlib.NewReader(rd)
+	if err != nil {
+		return "", nil, err
+	}
+	defer z.Close()
+	data, err := ioutil.ReadAll(z)
+	if err != nil {
+		return "", nil, err
+	}
+	nul := bytes.IndexByte(data, 0)
+	if nul == -1 {
+		return "", nil, errors.New("malformed loose object header")
+	}
+	header := strings.SplitN(string(data[0:nul]), " ", 2)
+	if len(header) != 2 {
+		return "", nil, errors.New("malformed loose object header")
+	}
+	return header[0], data[nul+1:], nil
+}
+
+const (
+	packIndexFanoutStart = 8
+	packIndexFanoutSize  = 256 * 4
+)
+
+// GitPackIndex is a version 2 pack index held in memory.
+type GitPackIndex struct {
+	Path string
+	Data []byte
+}
+
+func ReadGitPackIndex(path string) (GitPackIndex, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return GitPackIndex{}, err
+	}
+	if len(data) < packIndexFanoutStart+packIndexFanoutSize || !bytes.Equal(data[0:4], []byte{0xff, 't', 'O', 'c'}) {
+		return GitPackIndex{}, fmt.Errorf("%s is not a version 2 pack index", path)
+	}
+	if binary.BigEndian.Uint32(data[4:8]) != 2 {
+		return GitPackIndex{}, fmt.Errorf("%s is not a version 2 pack index", path)
+	}
+	return GitPackIndex{Path: path, Data: data}, nil
+}
+
+// Find looks a hash up and returns its offset in the corresponding pack.
+func (idx GitPackIndex) Find(want []byte) (int64, bool, error) {
+	data := idx.Data
+	fanout := func(i int) int {
+		return int(binary.BigEndian.Uint32(data[packIndexFanoutStart+i*4:]))
+	}
+	count := fanout(255)
+	lo := 0
+	if want[0] > 0 {
+		lo = fanout(int(want[0]) - 1)
+	}
+	hi := fanout(int(want[0]))
+	hashes := packIndexFanoutStart + packIndexFanoutSize
+	crcs := hashes + count*20
+	offsets := crcs + count*4
+	large := offsets + count*4
+	if len(data) < large {
+		return 0, false, fmt.Errorf("%s is truncated", idx.Path)
+	}
+	i := lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(data[hashes+(lo+i)*20:hashes+(lo+i+1)*20], want) >= 0
+	})
+	if i >= hi || !bytes.Equal(data[hashes+i*20:hashes+(i+1)*20], want) {
+		return 0, false, nil
+	}
+	offset := binary.BigEndian.Uint32(data[offsets+i*4:])
+	if offset&0x80000000 == 0 {
+		return int64(offset), true, nil
+	}
+	// The high bit marks an index into the table of 64-bit offsets.
+	j := large + int(offset&0x7fffffff)*8
+	if len(data) < j+8 {
+		return 0, false, fmt.Errorf("%s is truncated", idx.Path)
+	}
+	return int64(binary.BigEndian.Uint64(data[j:])), true, nil
+}
+
+const (
+	GIT_OBJ_COMMIT    = 1
+	GIT_OBJ_TREE      = 2
+	GIT_OBJ_BLOB      = 3
+	GIT_OBJ_TAG       = 4
+	GIT_OBJ_OFS_DELTA = 6
+	GIT_OBJ_REF_DELTA = 7
+)
+
+var gitObjectTypeNames = map[int]string{
+	GIT_OBJ_COMMIT: "commit",
+	GIT_OBJ_TREE:   "tree",
+	GIT_OBJ_BLOB:   "blob",
+	GIT_OBJ_TAG:    "tag",
+}
+
+type GitPack struct {
+	Repo *GitRepo
+	Path string
+}
+
+func (p GitPack) ReadObjectAt(offset int64) (string, []byte, error) {
+	f, err := os.Open(p.Path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer f.Close()
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return "", nil, err
+	}
+	rd := bufio.NewReader(f)
+	kind, err := readPackEntryType(rd)
+	if err != nil {
+		return "", nil, err
+	}
+	switch kind {
+	case GIT_OBJ_COMMIT, GIT_OBJ_TREE, GIT_OBJ_BLOB, GIT_OBJ_TAG:
+		data, err := inflate(rd)
+		return gitObjectTypeNames[kind], data, err
+	case GIT_OBJ_OFS_DELTA:
+		distance, err := readOfsDeltaDistance(rd)
+		if err != nil {
+			return "", nil, err
+		}
+		delta, err := inflate(rd)
+		if err != nil {
+			return "", nil, err
+		}
+		baseKind, base, err := p.ReadObjectAt(offset - distance)
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := ApplyGitDelta(base, delta)
+		return baseKind, data, err
+	case GIT_OBJ_REF_DELTA:
+		baseHash := make([]byte, 20)
+		_, err := io.ReadFull(rd, baseHash)
+		if err != nil {
+			return "", nil, err
+		}
+		delta, err := inflate(rd)
+		if err != nil {
+			return "", nil, err
+		}
+		baseKind, base, err := p.Repo.ReadObject(hex.EncodeToString(baseHash))
+		if err != nil {
+			return "", nil, err
+		}
+		data, err := ApplyGitDelta(base, delta)
+		return baseKind, data, err
+	default:
+		return "", nil, fmt.Errorf("unknown object type %d in %s", kind, p.Path)
+	}
+}
+
+// The entry header packs the type into bits 4-6 of the first byte,
+// followed by the variable length size, which inflate makes redundant.
+func readPackEntryType(rd *bufio.Reader) (int, error) {
+	b, err := rd.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	kind := int(b>>4) & 7
+	for b&0x80 != 0 {
+		b, err = rd.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return kind, nil
+}
+
+func readOfsDeltaDistance(rd *bufio.Reader) (int64, error) {
+	b, err := rd.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	distance := int64(b & 0x7f)
+	for b&0x80 != 0 {
+		b, err = rd.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		distance = ((distance + 1) << 7) | int64(b&0x7f)
+	}
+	return distance, nil
+}
+
+func inflate(rd io.Reader) ([]byte, error) {
+	z, err := zlib.NewReader(rd)
+	if err != nil {
+		return nil, err
+	}
+	defer z.Close()
+	return ioutil.ReadAll(z)
+}
+
+// ApplyGitDelta rebuilds an object from its base and a pack delta, which
+// is a sequence of copy-from-base and insert-literal instructions.
+func ApplyGitDelta(base []byte, delta []byte) ([]byte, error) {
+	rd := bytes.NewReader(delta)
+	baseSize, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	if baseSize != uint64(len(base)) {
+		return nil, errors.New("delta base size mismatch")
+	}
+	size, err := binary.ReadUvarint(rd)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, 0, size)
+	for {
+		op, err := rd.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if op&0x80 != 0 {
+			var offset, length uint32
+			for i := uint(0); i < 4; i++ {
+				if op&(1<<i) != 0 {
+					b, err := rd.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					offset |= uint32(b) << (8 * i)
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if op&(0x10<<i) != 0 {
+					b, err := rd.ReadByte()
+					if err != nil {
+						return nil, err
+					}
+					length |= uint32(b) << (8 * i)
+				}
+			}
+			if length == 0 {
+				length = 0x10000
+			}
+			if uint64(offset)+uint64(length) > uint64(len(base)) {
+				return nil, errors.New("delta copy out of range")
+			}
+			out = append(out, base[offset:offset+length]...)
+		} else if op != 0 {
+			literal := make([]byte, op)
+			_, err := io.ReadFull(rd, literal)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, literal...)
+		} else {
+			return nil, errors.New("reserved delta instruction")
+		}
+	}
+	if uint64(len(out)) != size {
+		return nil, errors.New("delta result size mismatch")
+	}
+	return out, nil
+}
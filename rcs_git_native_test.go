@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestApplyGitDelta(t *testing.T) {
+	base := []byte("the quick brown fox")
+	delta := []byte{
+		19,                // base size
+		20,                // result size
+		0x90 | 0x01, 4, 5, // copy 5 bytes from offset 4: "quick"
+		5, ' ', 'r', 'e', 'd', ' ', // insert " red "
+		0x90 | 0x01, 16, 3, // copy 3 bytes from offset 16: "fox"
+		7, ' ', 'j', 'u', 'm', 'p', 'e', 'd', // insert " jumped"
+	}
+	out, err := ApplyGitDelta(base, delta)
+	failWhenErr(t, err)
+	if string(out) != "quick red fox jumped" {
+		t.Fatalf("wanted 'quick red fox jumped' but got '%s'", out)
+	}
+}
+
+func TestApplyGitDeltaRejectsWrongBase(t *testing.T) {
+	_, err := ApplyGitDelta([]byte("abc"), []byte{4, 1, 1, 'x'})
+	failWhen(t, err == nil)
+}
+
+func TestParsePackedRefs(t *testing.T) {
+	packed := "# pack-refs with: peeled fully-peeled sorted\n" +
+		"1111111111111111111111111111111111111111 refs/heads/master\n" +
+		"2222222222222222222222222222222222222222 refs/tags/v1.0.0\n" +
+		"^3333333333333333333333333333333333333333\n"
+	refs, err := ParsePackedRefs(packed)
+	failWhenErr(t, err)
+	failWhen(t, len(refs) != 2)
+	failWhen(t, refs[0].Name != "refs/heads/master")
+	failWhen(t, refs[1].Hash != "2222222222222222222222222222222222222222")
+	failWhen(t, refs[1].Peeled != "3333333333333333333333333333333333333333")
+}
+
+func TestParseGitCommit(t *testing.T) {
+	data := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 1111111111111111111111111111111111111111\n" +
+		"parent 2222222222222222222222222222222222222222\n" +
+		"author A <a@example.com> 1500000000 +0000\n" +
+		"committer C <c@example.com> 1500000001 +0100\n" +
+		"\n" +
+		"parent 3333333333333333333333333333333333333333\n"
+	commit, err := ParseGitCommit([]byte(data))
+	failWhenErr(t, err)
+	failWhen(t, len(commit.Parents) != 2)
+	failWhen(t, commit.Committer != "C <c@example.com> 1500000001 +0100")
+	ct, err := ParseGitSignatureTime(commit.Committer)
+	failWhenErr(t, err)
+	failWhen(t, ct.Unix() != 1500000001)
+}
+
+func compareGitBackends(t *testing.T, repo string) {
+	exe := RcsGit{Root: repo}
+	native := NewRcsGitNative(repo)
+	var lookups = []struct {
+		Name string
+		F    func(Rcs) (string, error)
+	}{
+		{"branch", func(r Rcs) (string, error) { return r.Branch() }},
+		{"commit-counter", func(r Rcs) (string, error) { return r.CommitCounter() }},
+		{"commit-hash", func(r Rcs) (string, error) { return r.CommitHash() }},
+		{"commit-hash-short", func(r Rcs) (string, error) { return r.CommitHashShort() }},
+		{"last-tag", func(r Rcs) (string, error) { return r.LastTag() }},
+		{"tag-distance", func(r Rcs) (string, error) { return r.TagDistance() }},
+		{"exact-tag", func(r Rcs) (string, error) { return r.ExactTag() }},
+		{"commit-time", func(r Rcs) (string, error) {
+			ct, err := r.CommitTime()
+			return FormatTimeParameter(ct), err
+		}},
+		{"dirty", func(r Rcs) (string, error) {
+			dirty, err := r.Dirty()
+			return strconv.FormatBool(dirty), err
+		}},
+	}
+	for _, l := range lookups {
+		want, err := l.F(exe)
+		failWhenErr(t, err)
+		got, err := l.F(native)
+		failWhenErr(t, err)
+		if got != want {
+			t.Errorf("%s: git reports '%s' but native reader reports '%s'", l.Name, want, got)
+		}
+	}
+}
+
+func TestGitNativeMatchesGit(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	repo, err := ioutil.TempDir("", "vers-git-native")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "init", "-q")
+	// Similar commit messages encourage git to store commits as deltas
+	// once the repository is packed.
+	msg := strings.Repeat("a long and repetitive commit message ", 20)
+	gitCommit(t, repo, msg+"1")
+	runInDir(t, repo, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+		"tag", "-a", "v1.0.0", "-m", "first release")
+	gitCommit(t, repo, msg+"2")
+	runInDir(t, repo, "git", "checkout", "-q", "-b", "feature")
+	gitCommit(t, repo, msg+"3")
+	runInDir(t, repo, "git", "tag", "lightweight")
+	gitCommit(t, repo, msg+"4")
+	runInDir(t, repo, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+		"tag", "-a", "v1.1.0", "-m", "second release")
+	compareGitBackends(t, repo)
+
+	gitCommit(t, repo, msg+"5")
+	runInDir(t, repo, "git", "gc", "-q", "--aggressive")
+	compareGitBackends(t, repo)
+
+	runInDir(t, repo, "git", "checkout", "-q", "--detach", "v1.0.0")
+	compareGitBackends(t, repo)
+}
+
+func TestGitNativeSkipsTagsOfOtherObjects(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	repo, err := ioutil.TempDir("", "vers-git-native")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	tag := func(args ...string) {
+		runInDir(t, repo, "git", append([]string{"-c", "user.name=vers", "-c", "user.email=vers@example.com",
+			"tag", "-a", "-m", "tag"}, args...)...)
+	}
+	runInDir(t, repo, "git", "init", "-q")
+	failWhenErr(t, ioutil.WriteFile(filepath.Join(repo, "a.txt"), []byte("alpha"), 0644))
+	runInDir(t, repo, "git", "add", "a.txt")
+	gitCommit(t, repo, "first")
+	tag("v1.0.0")
+	gitCommit(t, repo, "second")
+	// A tag of a tag names the commit that the inner tag names, even
+	// once the inner tag's ref is gone.
+	tag("inner")
+	tag("outer", "inner")
+	runInDir(t, repo, "git", "tag", "-d", "inner")
+	tag("tree", "HEAD^{tree}")
+	tag("blob", "HEAD:a.txt")
+	gitCommit(t, repo, "third")
+	compareGitBackends(t, repo)
+	last, err := NewRcsGitNative(repo).LastTag()
+	failWhenErr(t, err)
+	failWhen(t, last != "outer")
+
+	runInDir(t, repo, "git", "pack-refs", "--all")
+	compareGitBackends(t, repo)
+}
+
+func TestGitNativeShallowClone(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	origin, err := ioutil.TempDir("", "vers-git-native")
+	failWhenErr(t, err)
+	defer os.RemoveAll(origin)
+	runInDir(t, origin, "git", "init", "-q")
+	for i := 1; i <= 4; i++ {
+		gitCommit(t, origin, fmt.Sprintf("commit %d", i))
+		if i == 2 {
+			runInDir(t, origin, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+				"tag", "-a", "v1.0.0", "-m", "first release")
+		}
+	}
+	clone, err := ioutil.TempDir("", "vers-git-native")
+	failWhenErr(t, err)
+	defer os.RemoveAll(clone)
+	runInDir(t, clone, "git", "clone", "-q", "--depth", "3", "file://"+origin, ".")
+	compareGitBackends(t, clone)
+	counter, err := NewRcsGitNative(clone).CommitCounter()
+	failWhenErr(t, err)
+	if counter != "3" {
+		t.Errorf("expected shallow commit-counter of 3 but got %s", counter)
+	}
+}
+
+func TestGitNativeDirty(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	repo, err := ioutil.TempDir("", "vers-git-native")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "init", "-q")
+	write := func(name string, content string) {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		failWhenErr(t, os.MkdirAll(filepath.Dir(path), 0755))
+		failWhenErr(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write("a.txt", "alpha")
+	write("sub/b.txt", "beta")
+	failWhenErr(t, os.Symlink("a.txt", filepath.Join(repo, "link")))
+	runInDir(t, repo, "git", "add", ".")
+	gitCommit(t, repo, "add files")
+	runInDir(t, repo, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+		"tag", "-a", "v1.0.0", "-m", "first release")
+	var cases = []struct {
+		Name    string
+		Change  func()
+		Restore func()
+		Dirty   bool
+	}{
+		{"clean", func() {}, func() {}, false},
+		{"modified", func() { write("a.txt", "ALPHA") }, func() { write("a.txt", "alpha") }, true},
+		{"staged", func() {
+			write("sub/b.txt", "gamma")
+			runInDir(t, repo, "git", "add", "sub/b.txt")
+		}, func() {
+			write("sub/b.txt", "beta")
+			runInDir(t, repo, "git", "add", "sub/b.txt")
+		}, true},
+		{"deleted", func() { os.Remove(filepath.Join(repo, "a.txt")) }, func() { write("a.txt", "alpha") }, true},
+		{"chmod", func() { os.Chmod(filepath.Join(repo, "a.txt"), 0755) }, func() { os.Chmod(filepath.Join(repo, "a.txt"), 0644) }, true},
+		{"relinked", func() {
+			os.Remove(filepath.Join(repo, "link"))
+			os.Symlink("sub/b.txt", filepath.Join(repo, "link"))
+		}, func() {
+			os.Remove(filepath.Join(repo, "link"))
+			os.Symlink("a.txt", filepath.Join(repo, "link"))
+		}, true},
+	}
+	for _, version := range []string{"2", "4"} {
+		runInDir(t, repo, "git", "update-index", "--index-version", version)
+		for _, tc := range cases {
+			tc.Change()
+			dirty, err := NewRcsGitNative(repo).Dirty()
+			failWhenErr(t, err)
+			if dirty != tc.Dirty {
+				t.Errorf("index version %s, %s: expected dirty %v but got %v", version, tc.Name, tc.Dirty, dirty)
+			}
+			compareGitBackends(t, repo)
+			tc.Restore()
+		}
+	}
+}
+
+func TestGitNativeUntracked(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	repo, err := ioutil.TempDir("", "vers-git-native")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	// Keep the user's own excludes file out of the comparison.
+	t.Setenv("HOME", repo)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(repo, "config"))
+	runInDir(t, repo, "git", "init", "-q")
+	write := func(name string, content string) {
+		path := filepath.Join(repo, filepath.FromSlash(name))
+		failWhenErr(t, os.MkdirAll(filepath.Dir(path), 0755))
+		failWhenErr(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	write(".gitignore", "*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/*.tmp\n")
+	write("sub/.gitignore", "*.dat\n")
+	write(".git/info/exclude", "secret\n")
+	runInDir(t, repo, "git", "add", ".")
+	gitCommit(t, repo, "add ignore files")
+	runInDir(t, repo, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+		"tag", "-a", "v1.0.0", "-m", "first release")
+	var cases = []struct {
+		Files []string
+		Dirty bool
+	}{
+		{[]string{}, false},
+		{[]string{"new.txt"}, true},
+		{[]string{"newdir/deep/f.txt"}, true},
+		{[]string{"debug.log", "sub/debug.log"}, false},
+		{[]string{"keep.log"}, true},
+		{[]string{"build/out.bin", "build/keep.log", "sub/build/x"}, false},
+		{[]string{"top.txt"}, false},
+		{[]string{"sub/top.txt"}, true},
+		{[]string{"docs/x.tmp", "docs/a/b/x.tmp"}, false},
+		{[]string{"docs/a/x.txt"}, true},
+		{[]string{"secret", "sub/secret"}, false},
+		{[]string{"sub/x.dat"}, false},
+		{[]string{"x.dat"}, true},
+	}
+	for _, tc := range cases {
+		for _, f := range tc.Files {
+			write(f, "x")
+		}
+		dirty, err := NewRcsGitNative(repo).Dirty()
+		failWhenErr(t, err)
+		if dirty != tc.Dirty {
+			t.Errorf("%v: expected dirty %v but got %v", tc.Files, tc.Dirty, dirty)
+		}
+		compareGitBackends(t, repo)
+		runInDir(t, repo, "git", "clean", "-q", "-f", "-d", "-x")
+	}
+	// Empty directories are not changes.
+	failWhenErr(t, os.MkdirAll(filepath.Join(repo, "empty", "dir"), 0755))
+	compareGitBackends(t, repo)
+}
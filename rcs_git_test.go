@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -21,3 +24,137 @@ func TestGitParseBranchTracking(t *testing.T) {
 		failWhen(t, b != tc.Want)
 	}
 }
+
+func TestGitParseDescribe(t *testing.T) {
+	var cases = []struct {
+		Describe string
+		Tag      string
+		Distance string
+	}{
+		{"v1.4.2-7-gabc123\n", "v1.4.2", "7"},
+		{"1.0.0-0-g0123456789abcdef0123456789abcdef01234567\n", "1.0.0", "0"},
+		{"release-1.0-rc2-12-gdeadbee\n", "release-1.0-rc2", "12"},
+	}
+	for _, tc := range cases {
+		tag, distance, err := ParseGitDescribe(tc.Describe)
+		failWhenErr(t, err)
+		failWhen(t, tag != tc.Tag)
+		failWhen(t, distance != tc.Distance)
+	}
+}
+
+func TestGitParseDescribeMalformed(t *testing.T) {
+	_, _, err := ParseGitDescribe("v1.4.2\n")
+	failWhen(t, err == nil)
+}
+
+func TestGitParseDirty(t *testing.T) {
+	var cases = []struct {
+		Status string
+		Want   bool
+	}{
+		{"## master...origin/master\n", false},
+		{"## master\n", false},
+		{"## master...origin/master\nA  rcs_git_test.go\n", true},
+		{"## master\n M main.go\n", true},
+		{"## master\n?? notes.txt\n", true},
+	}
+	for _, tc := range cases {
+		failWhen(t, ParseGitStatusDirty(tc.Status) != tc.Want)
+	}
+}
+
+func gitCommit(t *testing.T, dir string, msg string) {
+	runInDir(t, dir, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+		"commit", "-q", "--allow-empty", "-m", msg)
+}
+
+func TestGitShowFromOutsideRepo(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo, err := ioutil.TempDir("", "vers-git")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "init", "-q")
+	writeVersionFile(t, repo, "{branch}.{commit-counter}")
+	runInDir(t, repo, "git", "add", "version.json")
+	gitCommit(t, repo, "add version file")
+	gitCommit(t, repo, "second commit")
+	runInDir(t, repo, "git", "checkout", "-q", "-b", "feature")
+
+	// Run from inside a different repository to ensure that it is
+	// not the one being reported.
+	elsewhere, restore := chdirTemp(t)
+	defer restore()
+	runInDir(t, elsewhere, "git", "init", "-q")
+	runInDir(t, elsewhere, "git", "checkout", "-q", "-b", "other")
+	gitCommit(t, elsewhere, "unrelated commit")
+
+	ctx, err := NewBranchContext(filepath.Join(repo, "version.json"), "", []Option{})
+	failWhenErr(t, err)
+	version, err := ExpandVersion(ctx)
+	failWhenErr(t, err)
+	if version != "feature.2" {
+		t.Fatalf("wanted 'feature.2' but got '%s'", version)
+	}
+}
+
+func TestGitExactTag(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	repo, err := ioutil.TempDir("", "vers-git")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "init", "-q")
+	gitCommit(t, repo, "first commit")
+	rcs := RcsGit{Root: repo}
+
+	// No tags at all.
+	tag, err := rcs.ExactTag()
+	failWhenErr(t, err)
+	failWhen(t, tag != "")
+
+	runInDir(t, repo, "git", "-c", "user.name=vers", "-c", "user.email=vers@example.com",
+		"tag", "-a", "v1.0.0", "-m", "first release")
+	tag, err = rcs.ExactTag()
+	failWhenErr(t, err)
+	failWhen(t, tag != "v1.0.0")
+
+	// A tag, but not on HEAD.
+	gitCommit(t, repo, "second commit")
+	tag, err = rcs.ExactTag()
+	failWhenErr(t, err)
+	failWhen(t, tag != "")
+
+	// A broken repository is an error rather than a missing tag.
+	failWhenErr(t, ioutil.WriteFile(filepath.Join(repo, ".git", "HEAD"), []byte("garbage\n"), 0644))
+	_, err = rcs.ExactTag()
+	failWhen(t, err == nil)
+}
+
+func TestConditionalsInUntaggedRepository(t *testing.T) {
+	skipWithoutCommand(t, "git")
+	clearCiEnv(t)
+	repo, err := ioutil.TempDir("", "vers-git")
+	failWhenErr(t, err)
+	defer os.RemoveAll(repo)
+	runInDir(t, repo, "git", "init", "-q")
+	gitCommit(t, repo, "first commit")
+	var cases = []struct {
+		Template string
+		Want     string
+	}{
+		{"1.0{if tag-distance}-dev{end}", "1.0"},
+		{"1.0{?last-tag:-tagged}", "1.0"},
+		{"{if build-number}b{build-number}{else}local{end}", "local"},
+	}
+	for _, tc := range cases {
+		writeVersionFile(t, repo, tc.Template)
+		ctx, err := NewBranchContext(filepath.Join(repo, "version.json"), "", []Option{})
+		failWhenErr(t, err)
+		version, err := ExpandVersion(ctx)
+		failWhenErr(t, err)
+		if version != tc.Want {
+			t.Errorf("%s: wanted '%s' but got '%s'", tc.Template, tc.Want, version)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RcsHg struct {
+	Root string
+}
+
+func (v RcsHg) Name() string {
+	return "hg"
+}
+
+func (v RcsHg) Branch() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	// Bookmarks are Mercurial's closest analog to git branches, so
+	// an active bookmark wins over the named branch.
+	if info.Bookmark != "" {
+		return info.Bookmark, nil
+	}
+	return info.Branch, nil
+}
+
+func (v RcsHg) CommitCounter() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	return info.Rev, nil
+}
+
+func (v RcsHg) RepoCounter() (string, error) {
+	return "", errors.New("Mercurial does not support whole-repo commit counters")
+}
+
+func (v RcsHg) RepoRoot() (string, error) {
+	return "", errors.New("Mercurial does not support repo root")
+}
+
+func (v RcsHg) CommitHash() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	return info.Node, nil
+}
+
+func (v RcsHg) CommitHashShort() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	// Mercurial's short form of a node id is twelve characters.
+	if len(info.Node) < 12 {
+		return "", errors.New("malformed node id in hg output")
+	}
+	return info.Node[0:12], nil
+}
+
+func (v RcsHg) LastTag() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	if info.LatestTag == "" {
+		return "", NoTagError{Reason: "no tag reachable from working directory parent"}
+	}
+	return info.LatestTag, nil
+}
+
+func (v RcsHg) TagDistance() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	if info.LatestTag == "" {
+		return "", NoTagError{Reason: "no tag reachable from working directory parent"}
+	}
+	return info.LatestTagDistance, nil
+}
+
+func (v RcsHg) ExactTag() (string, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return "", err
+	}
+	if info.LatestTag == "" || info.LatestTagDistance != "0" {
+		return "", nil
+	}
+	return info.LatestTag, nil
+}
+
+func (v RcsHg) CommitTime() (time.Time, error) {
+	info, err := v.HgLog()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.Date, nil
+}
+
+func (v RcsHg) Dirty() (bool, error) {
+	out, err := RunRcsCommand(v.Root, "hg", "status")
+	if err != nil {
+		return false, err
+	}
+	// hg status lists modified, added, removed, missing, and untracked
+	// files, so any output at all means the working directory is dirty.
+	return strings.TrimSpace(out) != "", nil
+}
+
+const hgLogTemplate = "{rev}\\n{node}\\n{branch}\\n{activebookmark}\\n{latesttag}\\n{latesttagdistance}\\n{date|hgdate}\\n"
+
+func (v RcsHg) HgLog() (HgInfo, error) {
+	out, err := RunRcsCommand(v.Root, "hg", "log", "-r", ".", "--template", hgLogTemplate)
+	if err != nil {
+		return HgInfo{}, err
+	}
+	return ParseHgLog(out)
+}
+
+type HgInfo struct {
+	Rev               string
+	Node              string
+	Branch            string
+	Bookmark          string
+	LatestTag         string
+	LatestTagDistance string
+	Date              time.Time
+}
+
+func ParseHgLog(hgOut string) (HgInfo, error) {
+	lines := strings.Split(hgOut, "\n")
+	if len(lines) < 7 {
+		return HgInfo{}, errors.New("expected seven lines of hg log output")
+	}
+	// Ensure it can be converted to a number
+	rev, err := strconv.Atoi(lines[0])
+	if err != nil {
+		return HgInfo{}, errors.New("could not read revision as number")
+	}
+	if rev < 0 {
+		return HgInfo{}, errors.New("repository has no commits")
+	}
+	if lines[1] == "" {
+		return HgInfo{}, errors.New("could not find node in hg output")
+	}
+	if lines[2] == "" {
+		return HgInfo{}, errors.New("could not find branch in hg output")
+	}
+	// Mercurial reports "null" when no tag is reachable, and joins
+	// multiple tags on the same changeset with colons.
+	tag := strings.Split(lines[4], ":")[0]
+	if tag == "null" {
+		tag = ""
+	}
+	distance, err := strconv.Atoi(lines[5])
+	if err != nil {
+		return HgInfo{}, errors.New("could not read tag distance as number")
+	}
+	// hgdate is the unix time followed by the timezone offset.
+	date := strings.Fields(lines[6])
+	if len(date) != 2 {
+		return HgInfo{}, errors.New("could not find date in hg output")
+	}
+	secs, err := strconv.ParseInt(date[0], 10, 64)
+	if err != nil {
+		return HgInfo{}, errors.New("could not read date as number")
+	}
+	return HgInfo{
+		Rev:               strconv.Itoa(rev),
+		Node:              lines[1],
+		Branch:            lines[2],
+		Bookmark:          lines[3],
+		LatestTag:         tag,
+		LatestTagDistance: strconv.Itoa(distance),
+		Date:              time.Unix(secs, 0),
+	}, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestParseHgLog(t *testing.T) {
+	hgOut := "41\n" +
+		"9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n" +
+		"default\n" +
+		"feature-x\n" +
+		"1.4.2\n" +
+		"3\n" +
+		"1500000000 -3600\n"
+	info, err := ParseHgLog(hgOut)
+	failWhenErr(t, err)
+	failWhen(t, info.Rev != "41")
+	failWhen(t, info.Node != "9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123")
+	failWhen(t, info.Branch != "default")
+	failWhen(t, info.Bookmark != "feature-x")
+	failWhen(t, info.LatestTag != "1.4.2")
+	failWhen(t, info.LatestTagDistance != "3")
+	failWhen(t, info.Date.Unix() != 1500000000)
+}
+
+func TestParseHgLogWithoutBookmark(t *testing.T) {
+	hgOut := "7\n" +
+		"9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n" +
+		"stable\n" +
+		"\n" +
+		"null\n" +
+		"8\n" +
+		"1500000000 0\n"
+	info, err := ParseHgLog(hgOut)
+	failWhenErr(t, err)
+	failWhen(t, info.Branch != "stable")
+	failWhen(t, info.Bookmark != "")
+	failWhen(t, info.LatestTag != "")
+}
+
+func TestParseHgLogWithMultipleTags(t *testing.T) {
+	hgOut := "7\n" +
+		"9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n" +
+		"default\n" +
+		"\n" +
+		"v2.0.0:release-2\n" +
+		"0\n" +
+		"1500000000 0\n"
+	info, err := ParseHgLog(hgOut)
+	failWhenErr(t, err)
+	failWhen(t, info.LatestTag != "v2.0.0")
+	failWhen(t, info.LatestTagDistance != "0")
+}
+
+func TestParseHgLogMalformed(t *testing.T) {
+	var cases = []string{
+		"",
+		"41\n",
+		"x\n9f1c0a6b3c2e\ndefault\n\nnull\n0\n1500000000 0\n",
+		"-1\n0000000000000000000000000000000000000000\ndefault\n\nnull\n0\n0 0\n",
+		"41\n\ndefault\n\nnull\n0\n1500000000 0\n",
+		"41\n9f1c0a6b3c2e\ndefault\n\nnull\nx\n1500000000 0\n",
+		"41\n9f1c0a6b3c2e\ndefault\n\nnull\n0\n\n",
+	}
+	for _, tc := range cases {
+		_, err := ParseHgLog(tc)
+		failWhen(t, err == nil)
+	}
+}
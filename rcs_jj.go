@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RcsJj reads Jujutsu repositories.  Jujutsu's working copy is itself a
+// commit, so the parent of the working copy plays the role that HEAD does
+// in git, and changes in the working copy make the tree dirty.  This
+// matches what a colocated git repository reports as HEAD.
+type RcsJj struct {
+	Root string
+}
+
+func (v RcsJj) Name() string {
+	return "jj"
+}
+
+// Branch uses the bookmark on the working copy's parent, or failing that
+// the nearest bookmarked ancestor, since jj has no notion of a current
+// bookmark.
+func (v RcsJj) Branch() (string, error) {
+	out, err := v.Log("latest(::@- & bookmarks())", jjLogTemplate)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(out) == "" {
+		return "", errors.New("no bookmark on the working copy's ancestors")
+	}
+	info, err := ParseJjLog(out)
+	if err != nil {
+		return "", err
+	}
+	if len(info.Bookmarks) == 0 {
+		return "", errors.New("could not find bookmark in jj output")
+	}
+	return info.Bookmarks[0], nil
+}
+
+func (v RcsJj) CommitCounter() (string, error) {
+	out, err := v.Log("::@- ~ root()", jjIdTemplate)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(CountJjLog(out)), nil
+}
+
+func (v RcsJj) RepoCounter() (string, error) {
+	return "", errors.New("Jujutsu does not support whole-repo commit counters")
+}
+
+func (v RcsJj) RepoRoot() (string, error) {
+	return "", errors.New("Jujutsu does not support repo root")
+}
+
+func (v RcsJj) CommitHash() (string, error) {
+	info, err := v.JjLog()
+	if err != nil {
+		return "", err
+	}
+	return info.CommitId, nil
+}
+
+func (v RcsJj) CommitHashShort() (string, error) {
+	info, err := v.JjLog()
+	if err != nil {
+		return "", err
+	}
+	// jj shortens commit ids to twelve characters.
+	return info.CommitId[0:12], nil
+}
+
+func (v RcsJj) LastTag() (string, error) {
+	out, err := v.Log(jjLastTagRevset, jjLogTemplate)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(out) == "" {
+		return "", NoTagError{Reason: "no tag reachable from the working copy's parent"}
+	}
+	info, err := ParseJjLog(out)
+	if err != nil {
+		return "", err
+	}
+	if len(info.Tags) == 0 {
+		return "", errors.New("could not find tag in jj output")
+	}
+	return info.Tags[0], nil
+}
+
+func (v RcsJj) TagDistance() (string, error) {
+	_, err := v.LastTag()
+	if err != nil {
+		return "", err
+	}
+	out, err := v.Log(jjLastTagRevset+"..@-", jjIdTemplate)
+	if err != nil {
+		return "", err
+	}
+	return strconv.Itoa(CountJjLog(out)), nil
+}
+
+func (v RcsJj) ExactTag() (string, error) {
+	info, err := v.JjLog()
+	if err != nil {
+		return "", err
+	}
+	if len(info.Tags) == 0 {
+		return "", nil
+	}
+	return info.Tags[0], nil
+}
+
+func (v RcsJj) Dirty() (bool, error) {
+	// Unlike the other queries this snapshots the working copy, so that
+	// edits made since the last jj command are seen.
+	out, err := RunRcsCommand(v.Root, "jj", "log", "--no-graph", "--color", "never",
+		"-r", "@", "-T", `empty ++ "\n"`)
+	if err != nil {
+		return false, err
+	}
+	return ParseJjDirty(out)
+}
+
+func (v RcsJj) CommitTime() (time.Time, error) {
+	info, err := v.JjLog()
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.Date, nil
+}
+
+const jjLastTagRevset = "latest(::@- & tags())"
+
+const jjIdTemplate = `commit_id ++ "\n"`
+
+const jjLogTemplate = `commit_id ++ "\n" ++ ` +
+	`local_bookmarks.map(|b| b.name()).join(",") ++ "\n" ++ ` +
+	`tags.map(|t| t.name()).join(",") ++ "\n" ++ ` +
+	`committer.timestamp().utc().format("%s") ++ "\n"`
+
+// Log runs jj log without snapshotting the working copy, which would
+// otherwise record a new operation every time vers runs.
+func (v RcsJj) Log(revset string, template string) (string, error) {
+	return RunRcsCommand(v.Root, "jj", "log", "--no-graph", "--color", "never",
+		"--ignore-working-copy", "-r", revset, "-T", template)
+}
+
+func (v RcsJj) JjLog() (JjInfo, error) {
+	out, err := v.Log("@-", jjLogTemplate)
+	if err != nil {
+		return JjInfo{}, err
+	}
+	return ParseJjLog(out)
+}
+
+type JjInfo struct {
+	CommitId  string
+	Bookmarks []string
+	Tags      []string
+	Date      time.Time
+}
+
+func splitJjNames(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+	return strings.Split(s, ",")
+}
+
+func ParseJjLog(jjOut string) (JjInfo, error) {
+	lines := strings.Split(jjOut, "\n")
+	if len(lines) < 4 {
+		return JjInfo{}, errors.New("expected four lines of jj log output")
+	}
+	if len(lines[0]) < 12 || strings.Trim(lines[0], "0123456789abcdef") != "" {
+		return JjInfo{}, errors.New("could not find commit id in jj output")
+	}
+	if strings.Trim(lines[0], "0") == "" {
+		return JjInfo{}, errors.New("repository has no commits")
+	}
+	secs, err := strconv.ParseInt(lines[3], 10, 64)
+	if err != nil {
+		return JjInfo{}, errors.New("could not read date as number")
+	}
+	return JjInfo{
+		CommitId:  lines[0],
+		Bookmarks: splitJjNames(lines[1]),
+		Tags:      splitJjNames(lines[2]),
+		Date:      time.Unix(secs, 0),
+	}, nil
+}
+
+// CountJjLog counts the commits in a log with one commit id per line.
+func CountJjLog(jjOut string) int {
+	n := 0
+	for _, line := range strings.Split(jjOut, "\n") {
+		if strings.TrimSpace(line) != "" {
+			n++
+		}
+	}
+	return n
+}
+
+// ParseJjDirty reads jj's empty flag for the working copy commit, which
+// is only empty when the working copy is clean.
+func ParseJjDirty(jjOut string) (bool, error) {
+	empty, err := strconv.ParseBool(strings.TrimSpace(jjOut))
+	if err != nil {
+		return false, errors.New("could not read jj empty flag")
+	}
+	return !empty, nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseJjLog(t *testing.T) {
+	jjOut := "9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n" +
+		"main,feature-x\n" +
+		"v1.4.2\n" +
+		"1500000000\n"
+	info, err := ParseJjLog(jjOut)
+	failWhenErr(t, err)
+	failWhen(t, info.CommitId != "9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123")
+	failWhen(t, len(info.Bookmarks) != 2 || info.Bookmarks[0] != "main" || info.Bookmarks[1] != "feature-x")
+	failWhen(t, len(info.Tags) != 1 || info.Tags[0] != "v1.4.2")
+	failWhen(t, info.Date.Unix() != 1500000000)
+}
+
+func TestParseJjLogWithoutNames(t *testing.T) {
+	jjOut := "9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n\n\n1500000000\n"
+	info, err := ParseJjLog(jjOut)
+	failWhenErr(t, err)
+	failWhen(t, len(info.Bookmarks) != 0)
+	failWhen(t, len(info.Tags) != 0)
+}
+
+func TestParseJjLogMalformed(t *testing.T) {
+	var cases = []string{
+		"",
+		"9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n",
+		"9f1c0a6b\n\n\n1500000000\n",
+		"Error: revision not found\n\n\n1500000000\n",
+		"0000000000000000000000000000000000000000\n\n\n0\n",
+		"9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n\n\nyesterday\n",
+	}
+	for _, tc := range cases {
+		_, err := ParseJjLog(tc)
+		failWhen(t, err == nil)
+	}
+}
+
+func TestCountJjLog(t *testing.T) {
+	jjOut := "9f1c0a6b3c2e4d5f60718293a4b5c6d7e8f90123\n" +
+		"0a1b2c3d4e5f6a7b8c9d0e1f2a3b4c5d6e7f8a9b\n"
+	failWhen(t, CountJjLog(jjOut) != 2)
+	failWhen(t, CountJjLog("") != 0)
+}
+
+func TestParseJjDirty(t *testing.T) {
+	dirty, err := ParseJjDirty("false\n")
+	failWhenErr(t, err)
+	failWhen(t, !dirty)
+	dirty, err = ParseJjDirty("true\n")
+	failWhenErr(t, err)
+	failWhen(t, dirty)
+	_, err = ParseJjDirty("\n")
+	failWhen(t, err == nil)
+}
+
+func TestRcsDetection(t *testing.T) {
+	var cases = []struct {
+		Markers   []string
+		Preferred string
+		Want      string
+	}{
+		{[]string{".jj", ".git"}, "git", "git"},
+		{[]string{".jj", ".git"}, "git-native", "git-native"},
+		{[]string{".jj", ".git"}, "jj", "jj"},
+		{[]string{".hg", ".git"}, "hg", "hg"},
+		{[]string{".git", ".svn"}, "svn", "svn"},
+		{[]string{".git", ".fslckout"}, "fossil", "fossil"},
+		{[]string{".jj"}, "", "jj"},
+		{[]string{".fslckout"}, "", "fossil"},
+		{[]string{"_FOSSIL_"}, "", "fossil"},
+		{[]string{".hg"}, "", "hg"},
+	}
+	for _, tc := range cases {
+		dir, err := ioutil.TempDir("", "vers-detect")
+		failWhenErr(t, err)
+		defer os.RemoveAll(dir)
+		for _, m := range tc.Markers {
+			failWhenErr(t, os.Mkdir(filepath.Join(dir, m), 0775))
+		}
+		ok, err := IsRcsDir(dir)
+		failWhenErr(t, err)
+		failWhen(t, !ok)
+		rcs, err := getDirRcs(dir, tc.Preferred)
+		failWhenErr(t, err)
+		if rcs.Name() != tc.Want {
+			t.Fatalf("%v: wanted %s but got %s", tc.Markers, tc.Want, rcs.Name())
+		}
+	}
+}
+
+func TestPreferredRcsNeedsCheckout(t *testing.T) {
+	var cases = []struct {
+		Markers   []string
+		Preferred string
+	}{
+		{[]string{".jj"}, "git"},
+		{[]string{".git"}, "hg"},
+		{[]string{".git"}, "jj"},
+		{[]string{".hg"}, "git-native"},
+		{[]string{".svn"}, "fossil"},
+		{[]string{".fslckout"}, "svn"},
+	}
+	for _, tc := range cases {
+		dir, err := ioutil.TempDir("", "vers-detect")
+		failWhenErr(t, err)
+		defer os.RemoveAll(dir)
+		for _, m := range tc.Markers {
+			failWhenErr(t, os.Mkdir(filepath.Join(dir, m), 0775))
+		}
+		_, err = getDirRcs(dir, tc.Preferred)
+		if err == nil {
+			t.Fatalf("%v: wanted an error for %s", tc.Markers, tc.Preferred)
+		}
+		if !strings.Contains(err.Error(), dir) || !strings.Contains(err.Error(), tc.Preferred) {
+			t.Errorf("%v: error does not name the checkout: %s", tc.Markers, err)
+		}
+	}
+}
+
+func TestColocatedJjNeedsJjInstalled(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vers-detect")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	for _, m := range []string{".jj", ".git"} {
+		failWhenErr(t, os.Mkdir(filepath.Join(dir, m), 0775))
+	}
+	// Neither jj nor git can be found, so the native git reader is used.
+	bin := filepath.Join(dir, "bin")
+	failWhenErr(t, os.Mkdir(bin, 0775))
+	t.Setenv("PATH", bin)
+	rcs, err := getDirRcs(dir, "")
+	failWhenErr(t, err)
+	if rcs.Name() != "git-native" {
+		t.Errorf("wanted git-native without jj but got %s", rcs.Name())
+	}
+	failWhenErr(t, ioutil.WriteFile(filepath.Join(bin, "jj"), []byte("#!/bin/sh\n"), 0755))
+	rcs, err = getDirRcs(dir, "")
+	failWhenErr(t, err)
+	if rcs.Name() != "jj" {
+		t.Errorf("wanted jj once installed but got %s", rcs.Name())
+	}
+}
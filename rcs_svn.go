@@ -1,13 +1,13 @@
 package main
 
 import (
-	"bytes"
 	"encoding/xml"
 	"errors"
-	"os/exec"
+	"fmt"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type RcsSvn struct {
@@ -31,14 +31,11 @@ func (v RcsSvn) Branch() (string, error) {
 }
 
 func (v RcsSvn) CommitCounter() (string, error) {
-	cmd := exec.Command("svn", "log", "-l", "1", "--xml")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := RunRcsCommand(v.Root, "svn", "log", "-l", "1", "--xml")
 	if err != nil {
 		return "", err
 	}
-	return ParseRevisionFromXmlLog(out.String())
+	return ParseRevisionFromXmlLog(out)
 }
 
 func (v RcsSvn) RepoCounter() (string, error) {
@@ -77,15 +74,52 @@ func (v RcsSvn) CommitHashShort() (string, error) {
 	return "", errors.New("SVN does not support commit hashes")
 }
 
+func (v RcsSvn) LastTag() (string, error) {
+	return "", errors.New("SVN does not support tag history")
+}
+
+func (v RcsSvn) TagDistance() (string, error) {
+	return "", errors.New("SVN does not support tag history")
+}
+
+func (v RcsSvn) ExactTag() (string, error) {
+	info, err := v.SvnInfo()
+	if err != nil {
+		return "", err
+	}
+	path, ok := info["Relative URL"]
+	if !ok {
+		return "", errors.New("could not find URL in svn output")
+	}
+	return ParseTagFromSvnPath(path), nil
+}
+
+func (v RcsSvn) Dirty() (bool, error) {
+	out, err := RunRcsCommand(v.Root, "svn", "status")
+	if err != nil {
+		return false, err
+	}
+	return ParseSvnStatusDirty(out), nil
+}
+
+func (v RcsSvn) CommitTime() (time.Time, error) {
+	info, err := v.SvnInfo()
+	if err != nil {
+		return time.Time{}, err
+	}
+	date, ok := info["Last Changed Date"]
+	if !ok {
+		return time.Time{}, errors.New("could not find last changed date in svn output")
+	}
+	return ParseSvnDate(date)
+}
+
 func (v RcsSvn) SvnInfo() (map[string]string, error) {
-	cmd := exec.Command("svn", "info")
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	err := cmd.Run()
+	out, err := RunRcsCommand(v.Root, "svn", "info")
 	if err != nil {
 		return nil, err
 	}
-	return ParseSvnInfo(out.String())
+	return ParseSvnInfo(out)
 }
 
 func ParseSvnInfo(svnOut string) (map[string]string, error) {
@@ -123,6 +157,44 @@ func ParseBranchFromSvnPath(url string) (string, error) {
 	return "", errors.New("could not extract branch from svn URL")
 }
 
+// Any status line other than the ones describing svn:externals
+// indicates a modified, added, deleted, or untracked item.
+func ParseSvnStatusDirty(svnOut string) bool {
+	lines := strings.Split(svnOut, "\n")
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "X") || strings.HasPrefix(line, "Performing status on external item") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// ParseSvnDate reads dates such as "2003-01-13 16:43:13 -0600 (Mon, 13 Jan 2003)",
+// ignoring the localized text in parentheses.
+func ParseSvnDate(date string) (time.Time, error) {
+	parts := strings.SplitN(date, " (", 2)
+	t, err := time.Parse("2006-01-02 15:04:05 -0700", parts[0])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("could not read svn date '%s'", date)
+	}
+	return t, nil
+}
+
+// Tags in SVN are just copies under /tags, so a working copy is only
+// tagged when it is checked out from one.
+func ParseTagFromSvnPath(url string) string {
+	ptrn := regexp.MustCompile("/tags/([^/]+)")
+	m := ptrn.FindStringSubmatch(url)
+	if len(m) == 2 {
+		return string(m[1])
+	}
+	return ""
+}
+
 type LogRecord struct {
 	XMLName  xml.Name `xml:"log"`
 	LogEntry LogEntry `xml:"logentry"`
@@ -1,6 +1,9 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -27,6 +30,14 @@ func TestParseSvnInfo(t *testing.T) {
 	failWhen(t, v != "http://svn.red-bean.com/repos/test")
 }
 
+func TestParseSvnDate(t *testing.T) {
+	d, err := ParseSvnDate("2003-01-13 16:43:13 -0600 (Mon, 13 Jan 2003)")
+	failWhenErr(t, err)
+	failWhen(t, FormatTimeParameter(d) != "2003-01-13T22:43:13Z")
+	_, err = ParseSvnDate("yesterday")
+	failWhen(t, err == nil)
+}
+
 func TestParseRevisionFromXmlLog(t *testing.T) {
 	svnOut := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n" +
 		"<log>\n" +
@@ -70,3 +81,64 @@ func TestParseBranch(t *testing.T) {
 		failWhen(t, b != tc.Branch)
 	}
 }
+
+func TestParseTag(t *testing.T) {
+	var cases = []struct {
+		Url string
+		Tag string
+	}{
+		{"^/trunk/foo", ""},
+		{"^/branches/foo", ""},
+		{"^/tags/1.2.0", "1.2.0"},
+		{"^/tags/1.2.0/bar", "1.2.0"},
+	}
+	for _, tc := range cases {
+		failWhen(t, ParseTagFromSvnPath(tc.Url) != tc.Tag)
+	}
+}
+
+func TestParseSvnStatusDirty(t *testing.T) {
+	var cases = []struct {
+		Status string
+		Want   bool
+	}{
+		{"", false},
+		{"X       vendor/lib\n\nPerforming status on external item at 'vendor/lib':\n", false},
+		{"M       foo.c\n", true},
+		{"?       notes.txt\n", true},
+		{" M      bar\n", true},
+	}
+	for _, tc := range cases {
+		failWhen(t, ParseSvnStatusDirty(tc.Status) != tc.Want)
+	}
+}
+
+func TestSvnShowFromOutsideRepo(t *testing.T) {
+	skipWithoutCommand(t, "svn")
+	skipWithoutCommand(t, "svnadmin")
+	clearCiEnv(t)
+	tmp, err := ioutil.TempDir("", "vers-svn")
+	failWhenErr(t, err)
+	defer os.RemoveAll(tmp)
+	repo := filepath.Join(tmp, "repo")
+	wc := filepath.Join(tmp, "wc")
+	url := "file://" + filepath.ToSlash(repo)
+	runInDir(t, tmp, "svnadmin", "create", repo)
+	runInDir(t, tmp, "svn", "mkdir", "-q", "-m", "layout", url+"/trunk")
+	runInDir(t, tmp, "svn", "checkout", "-q", url+"/trunk", wc)
+	writeVersionFile(t, wc, "{branch}.{commit-counter}")
+	runInDir(t, wc, "svn", "add", "-q", "version.json")
+	runInDir(t, wc, "svn", "commit", "-q", "-m", "add version file")
+	runInDir(t, wc, "svn", "update", "-q")
+
+	_, restore := chdirTemp(t)
+	defer restore()
+
+	ctx, err := NewBranchContext(filepath.Join(wc, "version.json"), "", []Option{})
+	failWhenErr(t, err)
+	version, err := ExpandVersion(ctx)
+	failWhenErr(t, err)
+	if version != "trunk.2" {
+		t.Fatalf("wanted 'trunk.2' but got '%s'", version)
+	}
+}
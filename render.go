@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+// RenderFile expands a file as a template.  Every parameter is available,
+// along with the version itself.
+func RenderFile(ctx *Context, filename string) (string, error) {
+	text, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	t, err := ParseText(string(text))
+	if err != nil {
+		return "", fmt.Errorf("template %s is malformed: %s", filename, err)
+	}
+	out, err := t.Expand(ctx)
+	if err != nil {
+		return "", fmt.Errorf("could not render %s: %s", filename, err)
+	}
+	return out, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vers-render")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	tf := filepath.Join(dir, "app.spec.in")
+	failWhenErr(t, ioutil.WriteFile(tf, []byte("Name: app\nVersion: {version}\nRelease: {release}%\\{?dist}\n"), 0664))
+	config := Config{
+		Data: map[string]interface{}{"release": 3},
+		Branches: []BranchConfig{{
+			BranchPattern:   ".*",
+			VersionTemplate: "1.0.{release}",
+		}},
+	}
+	ctx := NewContext(filepath.Join(dir, "version.json"), &config, []Option{})
+	ctx.BranchConfig = &config.Branches[0]
+	ctx.State["version"] = "1.0.3"
+	out, err := RenderFile(&ctx, tf)
+	failWhenErr(t, err)
+	if out != "Name: app\nVersion: 1.0.3\nRelease: 3%{?dist}\n" {
+		t.Errorf("unexpected rendering %q", out)
+	}
+
+	failWhenErr(t, ioutil.WriteFile(tf, []byte("{undefined}"), 0664))
+	_, err = RenderFile(&ctx, tf)
+	failWhen(t, err == nil)
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// StampConfig names a manifest whose version field the stamp command
+// rewrites.  Type and Field default from the file name, and Parameter
+// defaults to version.
+type StampConfig struct {
+	File      string `json:"file"`
+	Type      string `json:"type,omitempty"`
+	Field     string `json:"field,omitempty"`
+	Parameter string `json:"parameter,omitempty"`
+}
+
+// Stamper sets a field in a manifest's contents, leaving everything else
+// byte for byte intact.
+type Stamper func(content []byte, field string, value string) ([]byte, error)
+
+type ManifestType struct {
+	Stamp        Stamper
+	DefaultField string
+	FilePattern  *regexp.Regexp
+}
+
+// ManifestTypes lists the supported manifests.  Fields are a top level key
+// for JSON and YAML, a dotted table and key for TOML, and a slash
+// separated element path for XML.
+var ManifestTypes = map[string]ManifestType{
+	"npm":       {StampJson, "version", regexp.MustCompile(`^package\.json$`)},
+	"cargo":     {StampToml, "package.version", regexp.MustCompile(`^Cargo\.toml$`)},
+	"pyproject": {StampToml, "project.version", regexp.MustCompile(`^pyproject\.toml$`)},
+	"pom":       {StampXml, "project/version", regexp.MustCompile(`^pom\.xml$`)},
+	"chart":     {StampYaml, "version", regexp.MustCompile(`^Chart\.yaml$`)},
+	"csproj":    {StampXml, "Project/PropertyGroup/Version", regexp.MustCompile(`\.csproj$`)},
+}
+
+// manifestType finds the type for a stamp entry, inferring it from the
+// file name when the entry does not say.
+func (sc StampConfig) manifestType() (ManifestType, error) {
+	if sc.Type != "" {
+		mt, ok := ManifestTypes[sc.Type]
+		if !ok {
+			return ManifestType{}, fmt.Errorf("unknown manifest type '%s' for %s", sc.Type, sc.File)
+		}
+		return mt, nil
+	}
+	for _, mt := range ManifestTypes {
+		if mt.FilePattern.MatchString(filepath.Base(sc.File)) {
+			return mt, nil
+		}
+	}
+	return ManifestType{}, fmt.Errorf("cannot tell the manifest type of %s", sc.File)
+}
+
+func checkStampConfig(stamps []StampConfig) error {
+	for _, sc := range stamps {
+		if sc.File == "" {
+			return fmt.Errorf("stamp entry requires a file")
+		}
+		_, err := sc.manifestType()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StampManifests updates every manifest in the version file's stamp
+// section and reports whether any of them changed.  Relative paths are
+// relative to the version file.
+func StampManifests(ctx *Context) (bool, error) {
+	root := filepath.Dir(ctx.VersionFile)
+	changed := false
+	for _, sc := range ctx.Config.Stamp {
+		mt, err := sc.manifestType()
+		if err != nil {
+			return false, err
+		}
+		field := sc.Field
+		if field == "" {
+			field = mt.DefaultField
+		}
+		param := sc.Parameter
+		if param == "" {
+			param = "version"
+		}
+		value, err := LookupParameter(param, ctx)
+		if err != nil {
+			return false, err
+		}
+		fn := sc.File
+		if !filepath.IsAbs(fn) {
+			fn = filepath.Join(root, fn)
+		}
+		content, err := ioutil.ReadFile(fn)
+		if err != nil {
+			return false, err
+		}
+		out, err := mt.Stamp(content, field, value)
+		if err != nil {
+			return false, fmt.Errorf("could not stamp %s: %s", sc.File, err)
+		}
+		written, err := WriteFileIfChanged(fn, out, 0664)
+		if err != nil {
+			return false, err
+		}
+		changed = changed || written
+	}
+	return changed, nil
+}
+
+func splice(content []byte, start int, end int, value string) []byte {
+	out := append([]byte{}, content[:start]...)
+	out = append(out, value...)
+	return append(out, content[end:]...)
+}
+
+// jsonFrame tracks an open object or array while scanning JSON tokens.
+type jsonFrame struct {
+	object bool
+	// expectKey is true when the next token in an object is a key.
+	expectKey bool
+}
+
+// StampJson replaces the string value of a top level key.
+func StampJson(content []byte, field string, value string) ([]byte, error) {
+	d := json.NewDecoder(bytes.NewReader(content))
+	stack := []jsonFrame{}
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no top level %s field", field)
+		} else if err != nil {
+			return nil, err
+		}
+		top := len(stack) - 1
+		if top >= 0 && stack[top].expectKey {
+			if tok == json.Delim('}') {
+				stack = stack[:top]
+				continue
+			}
+			stack[top].expectKey = false
+			if top == 0 && tok == field {
+				return stampJsonValue(content, d, field, value)
+			}
+			continue
+		}
+		if top >= 0 && stack[top].object {
+			stack[top].expectKey = true
+		}
+		switch tok {
+		case json.Delim('{'):
+			stack = append(stack, jsonFrame{object: true, expectKey: true})
+		case json.Delim('['):
+			stack = append(stack, jsonFrame{})
+		case json.Delim(']'):
+			stack = stack[:top]
+		}
+	}
+}
+
+// stampJsonValue replaces the value following the key just read from d.
+func stampJsonValue(content []byte, d *json.Decoder, field string, value string) ([]byte, error) {
+	start := int(d.InputOffset())
+	tok, err := d.Token()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := tok.(string); !ok {
+		return nil, fmt.Errorf("%s is not a string", field)
+	}
+	end := int(d.InputOffset())
+	// Skip the colon and whitespace between the key and the value.
+	start += bytes.IndexByte(content[start:end], '"')
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	err = enc.Encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return splice(content, start, end, strings.TrimSuffix(b.String(), "\n")), nil
+}
+
+// manifestLine is a line of a manifest along with its offset, so that
+// stampers can splice a replacement into the original contents.
+type manifestLine struct {
+	Offset int
+	Text   string
+}
+
+func manifestLines(content []byte) []manifestLine {
+	lines := []manifestLine{}
+	offset := 0
+	for _, l := range strings.SplitAfter(string(content), "\n") {
+		lines = append(lines, manifestLine{Offset: offset, Text: strings.TrimRight(l, "\r\n")})
+		offset += len(l)
+	}
+	return lines
+}
+
+// scanQuoted finds the end of a quoted string starting at s[0].  Backslash
+// escapes apply when escapes is true, and a doubled quote is an escaped
+// quote when doubled is true.
+func scanQuoted(s string, escapes bool, doubled bool) (int, error) {
+	q := s[0]
+	for i := 1; i < len(s); i++ {
+		if escapes && s[i] == '\\' {
+			i++
+		} else if s[i] == q {
+			if doubled && i+1 < len(s) && s[i+1] == q {
+				i++
+				continue
+			}
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string")
+}
+
+var tomlTablePtrn = regexp.MustCompile(`^\s*\[([^\[\]]+)\]\s*(#.*)?$`)
+var tomlArrayTablePtrn = regexp.MustCompile(`^\s*\[\[`)
+
+// StampToml replaces a string value given as table.key, or as a bare key
+// for the root table.
+func StampToml(content []byte, field string, value string) ([]byte, error) {
+	table := ""
+	key := field
+	if i := strings.LastIndex(field, "."); i >= 0 {
+		table = field[:i]
+		key = field[i+1:]
+	}
+	keyPtrn := regexp.MustCompile(`^\s*(` + regexp.QuoteMeta(key) + `|"` + regexp.QuoteMeta(key) + `")\s*=\s*`)
+	current := ""
+	for _, l := range manifestLines(content) {
+		if tomlArrayTablePtrn.MatchString(l.Text) {
+			// Arrays of tables never hold the field.
+			current = "[["
+			continue
+		}
+		if m := tomlTablePtrn.FindStringSubmatch(l.Text); m != nil {
+			parts := strings.Split(m[1], ".")
+			for i := range parts {
+				parts[i] = strings.TrimSpace(parts[i])
+			}
+			current = strings.Join(parts, ".")
+			continue
+		}
+		if current != table {
+			continue
+		}
+		m := keyPtrn.FindStringIndex(l.Text)
+		if m == nil {
+			continue
+		}
+		rest := l.Text[m[1]:]
+		var quoted string
+		if strings.HasPrefix(rest, `"`) && !strings.HasPrefix(rest, `"""`) {
+			n, err := scanQuoted(rest, true, false)
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[:n]
+			quoted = quoteDoubleQuoted(value)
+		} else if strings.HasPrefix(rest, `'`) && !strings.HasPrefix(rest, `'''`) {
+			n, err := scanQuoted(rest, false, false)
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[:n]
+			// Keep literal strings literal when the value allows it.
+			quoted = quoteDoubleQuoted(value)
+			if !strings.ContainsAny(value, "'\r\n") {
+				quoted = "'" + value + "'"
+			}
+		} else {
+			return nil, fmt.Errorf("%s is not a single line string", field)
+		}
+		start := l.Offset + m[1]
+		return splice(content, start, start+len(rest), quoted), nil
+	}
+	return nil, fmt.Errorf("no %s field", field)
+}
+
+var yamlPlainValuePtrn = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+_-]*$`)
+var yamlNumberPtrn = regexp.MustCompile(`^[-+]?(0[xXoObB][0-9A-Fa-f_]+|([0-9_]+(\.[0-9_]*)?|\.[0-9_]+)([eE][-+]?[0-9]+)?)$`)
+var yamlKeywordPtrn = regexp.MustCompile(`^(?i:y|n|yes|no|on|off|true|false|null|~|\.inf|\.nan)$`)
+
+// yamlPlainSafe reports whether a value can be written unquoted and still
+// read back as the same string.
+func yamlPlainSafe(value string) bool {
+	return yamlPlainValuePtrn.MatchString(value) &&
+		!yamlNumberPtrn.MatchString(value) &&
+		!yamlKeywordPtrn.MatchString(value)
+}
+
+// StampYaml replaces the scalar value of a top level key, keeping its
+// quoting style where the new value allows it.
+func StampYaml(content []byte, field string, value string) ([]byte, error) {
+	keyPtrn := regexp.MustCompile(`^(` + regexp.QuoteMeta(field) + `|"` + regexp.QuoteMeta(field) + `"|'` + regexp.QuoteMeta(field) + `')\s*:[ \t]*`)
+	for _, l := range manifestLines(content) {
+		m := keyPtrn.FindStringIndex(l.Text)
+		if m == nil {
+			continue
+		}
+		rest := l.Text[m[1]:]
+		quoted := quoteDoubleQuoted(value)
+		if strings.HasPrefix(rest, `"`) {
+			n, err := scanQuoted(rest, true, false)
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[:n]
+		} else if strings.HasPrefix(rest, `'`) {
+			n, err := scanQuoted(rest, false, true)
+			if err != nil {
+				return nil, err
+			}
+			rest = rest[:n]
+			if !strings.ContainsAny(value, "\r\n") {
+				quoted = "'" + strings.Replace(value, "'", "''", -1) + "'"
+			}
+		} else {
+			if i := strings.Index(rest, " #"); i >= 0 {
+				rest = rest[:i]
+			}
+			rest = strings.TrimRight(rest, " \t")
+			if rest == "" || strings.ContainsAny(rest[:1], "|>[{&*!") {
+				return nil, fmt.Errorf("%s is not a single line scalar", field)
+			}
+			if yamlPlainSafe(value) {
+				quoted = value
+			}
+		}
+		start := l.Offset + m[1]
+		return splice(content, start, start+len(rest), quoted), nil
+	}
+	return nil, fmt.Errorf("no top level %s field", field)
+}
+
+// StampXml replaces the text of the first element at a slash separated
+// path from the root, so that project/version in a POM skips the
+// parent's and the dependencies' versions.
+func StampXml(content []byte, field string, value string) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(content))
+	path := []string{}
+	start := -1
+	for {
+		offset := int(d.InputOffset())
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("no %s element", field)
+		} else if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if start >= 0 {
+				return nil, fmt.Errorf("%s contains elements", field)
+			}
+			path = append(path, t.Name.Local)
+			if strings.Join(path, "/") == field {
+				start = int(d.InputOffset())
+				if bytes.HasSuffix(content[:start], []byte("/>")) {
+					return nil, fmt.Errorf("%s is an empty element", field)
+				}
+			}
+		case xml.EndElement:
+			if start >= 0 {
+				var b bytes.Buffer
+				err := xml.EscapeText(&b, []byte(value))
+				if err != nil {
+					return nil, err
+				}
+				return splice(content, start, offset, b.String()), nil
+			}
+			path = path[:len(path)-1]
+		}
+	}
+}
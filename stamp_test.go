@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var stampTests = []struct {
+	Stamp    Stamper
+	Field    string
+	Value    string
+	Manifest string
+	Expected string
+}{
+	{StampJson, "version", "1.2.3",
+		"{\n  \"name\": \"app\",\n  \"version\": \"0.0.1\",\n  \"dependencies\": {\"version\": \"9\"}\n}\n",
+		"{\n  \"name\": \"app\",\n  \"version\": \"1.2.3\",\n  \"dependencies\": {\"version\": \"9\"}\n}\n"},
+	{StampJson, "version", "1.2.3",
+		"{\"config\": {\"version\": \"9\"}, \"list\": [{\"version\": \"8\"}], \"version\" :  \"0.0.1\"}",
+		"{\"config\": {\"version\": \"9\"}, \"list\": [{\"version\": \"8\"}], \"version\" :  \"1.2.3\"}"},
+	{StampJson, "version", "<\"quoted\">",
+		"{\"version\": \"\"}",
+		"{\"version\": \"<\\\"quoted\\\">\"}"},
+	{StampToml, "package.version", "1.2.3",
+		"[package]\nname = \"app\"\nversion = \"0.0.1\" # keep me\n\n[dependencies]\nversion = \"9\"\n",
+		"[package]\nname = \"app\"\nversion = \"1.2.3\" # keep me\n\n[dependencies]\nversion = \"9\"\n"},
+	{StampToml, "project.version", "1.2.3",
+		"[tool.other]\nversion = \"9\"\n[ project ]\nversion='0.0.1'\n",
+		"[tool.other]\nversion = \"9\"\n[ project ]\nversion='1.2.3'\n"},
+	{StampToml, "tool.poetry.version", "it's",
+		"[tool.poetry]\r\nversion = '0.0.1'\r\n",
+		"[tool.poetry]\r\nversion = \"it's\"\r\n"},
+	{StampToml, "version", "1.2.3",
+		"version = \"0\"\n[package]\nversion = \"9\"\n",
+		"version = \"1.2.3\"\n[package]\nversion = \"9\"\n"},
+	{StampYaml, "version", "1.2.3",
+		"apiVersion: v2\nname: app\nversion: 0.0.1 # chart version\nappVersion: \"0.0.1\"\n",
+		"apiVersion: v2\nname: app\nversion: 1.2.3 # chart version\nappVersion: \"0.0.1\"\n"},
+	{StampYaml, "appVersion", "1.2.3",
+		"version: 0.0.1\nappVersion: \"0.0.1\"\ndependencies:\n  - version: 9\n",
+		"version: 0.0.1\nappVersion: \"1.2.3\"\ndependencies:\n  - version: 9\n"},
+	{StampYaml, "appVersion", "1.10",
+		"appVersion: 1.9\n",
+		"appVersion: \"1.10\"\n"},
+	{StampYaml, "appVersion", "it's",
+		"appVersion: 'x'\n",
+		"appVersion: 'it''s'\n"},
+	{StampXml, "project/version", "1.2.3",
+		"<project xmlns=\"http://maven.apache.org/POM/4.0.0\">\n  <parent><version>9</version></parent>\n  <!-- the version -->\n  <version>0.0.1</version>\n</project>\n",
+		"<project xmlns=\"http://maven.apache.org/POM/4.0.0\">\n  <parent><version>9</version></parent>\n  <!-- the version -->\n  <version>1.2.3</version>\n</project>\n"},
+	{StampXml, "Project/PropertyGroup/Version", "1.2.3-a&b",
+		"<Project Sdk=\"Microsoft.NET.Sdk\">\n  <PropertyGroup>\n    <Version>0.0.1</Version>\n  </PropertyGroup>\n</Project>\n",
+		"<Project Sdk=\"Microsoft.NET.Sdk\">\n  <PropertyGroup>\n    <Version>1.2.3-a&amp;b</Version>\n  </PropertyGroup>\n</Project>\n"},
+	{StampXml, "Project/PropertyGroup/Version", "1.2.3",
+		"<Project><PropertyGroup><Version></Version></PropertyGroup></Project>",
+		"<Project><PropertyGroup><Version>1.2.3</Version></PropertyGroup></Project>"},
+}
+
+func TestStamp(t *testing.T) {
+	for _, tc := range stampTests {
+		out, err := tc.Stamp([]byte(tc.Manifest), tc.Field, tc.Value)
+		failWhenErr(t, err)
+		if string(out) != tc.Expected {
+			t.Errorf("%s: wanted %q but got %q", tc.Field, tc.Expected, string(out))
+		}
+	}
+}
+
+var stampErrorTests = []struct {
+	Stamp    Stamper
+	Field    string
+	Manifest string
+}{
+	{StampJson, "version", "{\"name\": \"app\"}"},
+	{StampJson, "version", "{\"version\": 1}"},
+	{StampJson, "version", "{\"version\": "},
+	{StampToml, "package.version", "[package]\nversion.workspace = true\n"},
+	{StampToml, "package.version", "[workspace.package]\nversion = \"1\"\n"},
+	{StampYaml, "version", "name: app\n"},
+	{StampYaml, "version", "version: |\n  1.0\n"},
+	{StampXml, "project/version", "<project><parent><version>1</version></parent></project>"},
+	{StampXml, "Project/PropertyGroup/Version", "<Project><PropertyGroup><Version/></PropertyGroup></Project>"},
+	{StampXml, "project/version", "<project><version><x/></version></project>"},
+}
+
+func TestStampErrors(t *testing.T) {
+	for _, tc := range stampErrorTests {
+		_, err := tc.Stamp([]byte(tc.Manifest), tc.Field, "1.2.3")
+		if err == nil {
+			t.Errorf("%s: expected error for %q", tc.Field, tc.Manifest)
+		}
+	}
+}
+
+var manifestTypeTests = []struct {
+	Stamp StampConfig
+	Valid bool
+}{
+	{StampConfig{File: "package.json"}, true},
+	{StampConfig{File: "rust/Cargo.toml"}, true},
+	{StampConfig{File: "app/App.csproj"}, true},
+	{StampConfig{File: "manifest.json"}, false},
+	{StampConfig{File: "manifest.json", Type: "npm"}, true},
+	{StampConfig{File: "package.json", Type: "gradle"}, false},
+}
+
+func TestManifestType(t *testing.T) {
+	for _, tc := range manifestTypeTests {
+		_, err := tc.Stamp.manifestType()
+		if (err == nil) != tc.Valid {
+			t.Errorf("%v: expected valid=%v but got %v", tc.Stamp, tc.Valid, err)
+		}
+	}
+}
+
+func TestStampManifests(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vers-stamp")
+	failWhenErr(t, err)
+	defer os.RemoveAll(dir)
+	chart := filepath.Join(dir, "Chart.yaml")
+	failWhenErr(t, ioutil.WriteFile(chart, []byte("version: 0.0.1\nappVersion: 0.0.1\n"), 0664))
+	config := Config{
+		Branches: []BranchConfig{{
+			BranchPattern:   ".*",
+			VersionTemplate: "1.2.3",
+		}},
+		Stamp: []StampConfig{
+			{File: "Chart.yaml"},
+			{File: "Chart.yaml", Field: "appVersion", Parameter: "build"},
+		},
+	}
+	ctx := NewContext(filepath.Join(dir, "version.json"), &config, []Option{{Name: "build", Value: "abc"}})
+	ctx.BranchConfig = &config.Branches[0]
+	ctx.State["version"] = "1.2.3"
+	changed, err := StampManifests(&ctx)
+	failWhenErr(t, err)
+	failWhen(t, !changed)
+	out, err := ioutil.ReadFile(chart)
+	failWhenErr(t, err)
+	if string(out) != "version: 1.2.3\nappVersion: abc\n" {
+		t.Errorf("unexpected Chart.yaml %q", string(out))
+	}
+	changed, err = StampManifests(&ctx)
+	failWhenErr(t, err)
+	failWhen(t, changed)
+}